@@ -0,0 +1,13 @@
+package main
+
+import (
+	"log"
+
+	"gozon/gateway-service/internal/app"
+)
+
+func main() {
+	if err := app.Run(); err != nil {
+		log.Fatalf("gateway service failed: %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+module gozon/gateway-service
+
+go 1.25.1
+
+require (
+	github.com/google/uuid v1.6.0
+	golang.org/x/time v0.9.0
+)
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gozon/gateway-service/internal/config"
+	"gozon/gateway-service/internal/httpapi"
+	"gozon/pkg/auth"
+)
+
+type App struct {
+	cfg      config.Config
+	logger   *slog.Logger
+	limiters []*httpapi.RateLimiter
+	httpSrv  *http.Server
+}
+
+func New(cfg config.Config, logger *slog.Logger) (*App, error) {
+	authn, err := auth.New(auth.Config{
+		Mode:     auth.Mode(cfg.AuthMode),
+		KeyFile:  cfg.AuthKeyFile,
+		JWKSFile: cfg.AuthJWKSFile,
+		Issuer:   cfg.AuthIssuer,
+		Audience: cfg.AuthAudience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init auth: %w", err)
+	}
+	if authn.Mode() == auth.ModeHeader {
+		logger.Warn("auth mode is header, X-User-ID is trusted as is; use for local development only")
+	}
+
+	api, err := httpapi.NewServer(cfg.OrdersURL, cfg.PaymentsURL, cfg.UpstreamTimeout, cfg.SummaryOrders, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	perIP := httpapi.NewRateLimiter(cfg.IPRateLimit, cfg.IPRateBurst, httpapi.IPKey)
+	perUser := httpapi.NewRateLimiter(cfg.RateLimit, cfg.RateBurst, httpapi.ClientKey)
+
+	return &App{
+		cfg:      cfg,
+		logger:   logger,
+		limiters: []*httpapi.RateLimiter{perIP, perUser},
+		httpSrv: &http.Server{
+			Addr:    cfg.HTTPAddr,
+			Handler: newHandler(api, authn, perIP, perUser),
+		},
+	}, nil
+}
+
+// newHandler wraps api in the middleware chain. The per-IP limit runs
+// before authentication so that requests with bad or missing tokens are
+// limited too; the per-user limit runs after it so that it can key on the
+// user.
+func newHandler(api http.Handler, authn *auth.Authenticator, perIP, perUser *httpapi.RateLimiter) http.Handler {
+	return httpapi.RequestID(perIP.Middleware(authn.Middleware(perUser.Middleware(api), "GET /healthz")))
+}
+
+func (a *App) Run(ctx context.Context) error {
+	for _, l := range a.limiters {
+		go l.Run(ctx)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		a.logger.Info("gateway http server listening", "addr", a.cfg.HTTPAddr)
+		if err := a.httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (a *App) Close(ctx context.Context) {
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.cfg.ShutdownGracePeriod)
+	defer cancel()
+	_ = a.httpSrv.Shutdown(shutdownCtx)
+}
+
+func Run() error {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	cfg := config.Load()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	app, err := New(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("init app: %w", err)
+	}
+	defer app.Close(ctx)
+
+	return app.Run(ctx)
+}
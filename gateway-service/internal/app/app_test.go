@@ -0,0 +1,54 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gozon/gateway-service/internal/httpapi"
+	"gozon/pkg/auth"
+
+	"github.com/google/uuid"
+)
+
+func TestHandlerRateLimits(t *testing.T) {
+	authn, err := auth.New(auth.Config{Mode: auth.ModeHeader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	perIP := httpapi.NewRateLimiter(0.001, 3, httpapi.IPKey)
+	perUser := httpapi.NewRateLimiter(0.001, 1, httpapi.ClientKey)
+	handler := newHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), authn, perIP, perUser)
+
+	alice := uuid.NewString()
+	steps := []struct {
+		name   string
+		user   string
+		remote string
+		want   int
+	}{
+		{name: "bad credentials 1", user: "not-a-uuid", remote: "10.0.0.1:1000", want: http.StatusUnauthorized},
+		{name: "no credentials", remote: "10.0.0.1:1001", want: http.StatusUnauthorized},
+		{name: "bad credentials 2", user: "not-a-uuid", remote: "10.0.0.1:1002", want: http.StatusUnauthorized},
+		{name: "bad credentials over the address limit", user: "not-a-uuid", remote: "10.0.0.1:1003", want: http.StatusTooManyRequests},
+		{name: "valid user from the same address", user: alice, remote: "10.0.0.1:1004", want: http.StatusTooManyRequests},
+		{name: "alice from another address", user: alice, remote: "10.0.0.2:1000", want: http.StatusOK},
+		{name: "alice over the user limit", user: alice, remote: "10.0.0.3:1000", want: http.StatusTooManyRequests},
+		{name: "bob from alice's address", user: uuid.NewString(), remote: "10.0.0.2:1001", want: http.StatusOK},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.RemoteAddr = step.remote
+		if step.user != "" {
+			req.Header.Set(auth.UserHeader, step.user)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, rec.Code, step.want)
+		}
+		if rec.Header().Get(httpapi.RequestIDHeader) == "" {
+			t.Errorf("%s: response without %s", step.name, httpapi.RequestIDHeader)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	HTTPAddr            string
+	OrdersURL           string
+	PaymentsURL         string
+	UpstreamTimeout     time.Duration
+	RateLimit           float64
+	RateBurst           int
+	IPRateLimit         float64
+	IPRateBurst         int
+	SummaryOrders       int
+	AuthMode            string
+	AuthKeyFile         string
+	AuthJWKSFile        string
+	AuthIssuer          string
+	AuthAudience        string
+	ShutdownGracePeriod time.Duration
+}
+
+func getEnv(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func Load() Config {
+	return Config{
+		HTTPAddr:            getEnv("GATEWAY_HTTP_ADDR", ":8000"),
+		OrdersURL:           getEnv("GATEWAY_ORDERS_URL", "http://orders-service:8080"),
+		PaymentsURL:         getEnv("GATEWAY_PAYMENTS_URL", "http://payments-service:8081"),
+		UpstreamTimeout:     parseDuration("GATEWAY_UPSTREAM_TIMEOUT", 10*time.Second),
+		RateLimit:           parseFloat("GATEWAY_RATE_LIMIT", 20),
+		RateBurst:           parseInt("GATEWAY_RATE_BURST", 40),
+		IPRateLimit:         parseFloat("GATEWAY_IP_RATE_LIMIT", 100),
+		IPRateBurst:         parseInt("GATEWAY_IP_RATE_BURST", 200),
+		SummaryOrders:       parseInt("GATEWAY_SUMMARY_ORDERS", 5),
+		AuthMode:            getEnv("GATEWAY_AUTH_MODE", "jwt"),
+		AuthKeyFile:         getEnv("GATEWAY_AUTH_KEY_FILE", ""),
+		AuthJWKSFile:        getEnv("GATEWAY_AUTH_JWKS_FILE", ""),
+		AuthIssuer:          getEnv("GATEWAY_AUTH_ISSUER", ""),
+		AuthAudience:        getEnv("GATEWAY_AUTH_AUDIENCE", ""),
+		ShutdownGracePeriod: parseDuration("GATEWAY_SHUTDOWN_TIMEOUT", 10*time.Second),
+	}
+}
+
+func parseDuration(key string, def time.Duration) time.Duration {
+	if raw, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(raw); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+func parseInt(key string, def int) int {
+	if raw, ok := os.LookupEnv(key); ok {
+		if v, err := strconv.Atoi(raw); err == nil {
+			return v
+		}
+	}
+	return def
+}
+
+func parseFloat(key string, def float64) float64 {
+	if raw, ok := os.LookupEnv(key); ok {
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			return v
+		}
+	}
+	return def
+}
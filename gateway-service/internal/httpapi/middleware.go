@@ -0,0 +1,144 @@
+package httpapi
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gozon/pkg/auth"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID keeps a well-formed incoming X-Request-ID or assigns a new one,
+// and sets it on both the request (so upstreams see it) and the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RateKey names the client a request is counted against.
+type RateKey func(r *http.Request) string
+
+// RateLimiter applies a token bucket per client, as named by its RateKey.
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+	key   RateKey
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+}
+
+type rateClient struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+const rateClientIdle = 10 * time.Minute
+
+func NewRateLimiter(perSecond float64, burst int, key RateKey) *RateLimiter {
+	return &RateLimiter{
+		limit:   rate.Limit(perSecond),
+		burst:   max(burst, 1),
+		key:     key,
+		clients: make(map[string]*rateClient),
+	}
+}
+
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	if l.limit <= 0 {
+		return next
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(1 / float64(l.limit))))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(l.key(r)) {
+			w.Header().Set("Retry-After", retryAfter)
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *RateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.seen = time.Now()
+	return c.limiter.Allow()
+}
+
+// Run forgets clients that have been idle long enough for their bucket to
+// refill, so the map does not grow without bound.
+func (l *RateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().Add(-rateClientIdle)
+		l.mu.Lock()
+		for key, c := range l.clients {
+			if c.seen.Before(cutoff) {
+				delete(l.clients, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// ClientKey counts requests against the authenticated user when there is
+// one and the remote address otherwise.
+func ClientKey(r *http.Request) string {
+	if userID, err := auth.UserFrom(r.Context()); err == nil {
+		return "user:" + userID.String()
+	}
+	return IPKey(r)
+}
+
+// IPKey counts requests against the remote address. It needs no
+// authentication, so it also limits requests with bad or missing tokens.
+func IPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
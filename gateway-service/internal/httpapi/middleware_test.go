@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gozon/pkg/auth"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "missing", incoming: ""},
+		{name: "well formed", incoming: "req-42", keep: true},
+		{name: "too long", incoming: strings.Repeat("a", 129)},
+		{name: "control characters", incoming: "req\t42"},
+		{name: "non-ascii", incoming: "запрос"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstream string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstream = r.Header.Get(RequestIDHeader)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if got != upstream {
+				t.Errorf("response id %q, upstream id %q, want the same", got, upstream)
+			}
+			if tt.keep && got != tt.incoming {
+				t.Errorf("id = %q, want the incoming %q", got, tt.incoming)
+			}
+			if !tt.keep {
+				if _, err := uuid.Parse(got); err != nil {
+					t.Errorf("id = %q, want a fresh uuid", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(0.001, 2, ClientKey)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	alice, bob := uuid.New(), uuid.New()
+	do := func(user uuid.UUID, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.RemoteAddr = remote
+		if user != uuid.Nil {
+			req = req.WithContext(auth.WithUser(req.Context(), user))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	steps := []struct {
+		name   string
+		user   uuid.UUID
+		remote string
+		want   int
+	}{
+		{name: "alice 1", user: alice, remote: "10.0.0.1:1000", want: http.StatusOK},
+		{name: "alice 2 from another address", user: alice, remote: "10.0.0.2:1000", want: http.StatusOK},
+		{name: "alice over burst", user: alice, remote: "10.0.0.3:1000", want: http.StatusTooManyRequests},
+		{name: "bob on alice's address", user: bob, remote: "10.0.0.1:1000", want: http.StatusOK},
+		{name: "anonymous 1", remote: "10.0.0.9:1000", want: http.StatusOK},
+		{name: "anonymous 2 other port", remote: "10.0.0.9:2000", want: http.StatusOK},
+		{name: "anonymous over burst", remote: "10.0.0.9:3000", want: http.StatusTooManyRequests},
+		{name: "another anonymous address", remote: "10.0.0.10:1000", want: http.StatusOK},
+	}
+	for _, step := range steps {
+		rec := do(step.user, step.remote)
+		if rec.Code != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, rec.Code, step.want)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: 429 without Retry-After", step.name)
+		}
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewRateLimiter(0, 1, ClientKey).Middleware(next)
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d with the limiter off", i, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gozon/pkg/auth"
+)
+
+type Server struct {
+	orders   *url.URL
+	payments *url.URL
+	client   *http.Client
+	summary  int
+	logger   *slog.Logger
+	mux      *http.ServeMux
+}
+
+// NewServer proxies to the orders and payments services. timeout bounds
+// calls the gateway makes itself and the wait for upstream response
+// headers; proxied WebSocket connections are not limited by it.
+func NewServer(ordersURL, paymentsURL string, timeout time.Duration, summaryOrders int, logger *slog.Logger) (*Server, error) {
+	orders, err := url.Parse(ordersURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse orders url: %w", err)
+	}
+	payments, err := url.Parse(paymentsURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse payments url: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+
+	s := &Server{
+		orders:   orders,
+		payments: payments,
+		client:   &http.Client{Transport: transport, Timeout: timeout},
+		summary:  summaryOrders,
+		logger:   logger,
+		mux:      http.NewServeMux(),
+	}
+
+	s.routes(transport)
+	return s, nil
+}
+
+func (s *Server) routes(transport http.RoundTripper) {
+	orders := requireUser(s.proxy("orders", s.orders, transport))
+	payments := requireUser(s.proxy("payments", s.payments, transport))
+
+	s.mux.Handle("/orders", orders)
+	s.mux.Handle("/orders/", orders)
+	s.mux.Handle("/accounts", payments)
+	s.mux.Handle("/accounts/", payments)
+	s.mux.HandleFunc("GET /me/summary", s.meSummary)
+	s.mux.HandleFunc("GET /healthz", s.healthz)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// proxy forwards requests to target. The caller's own X-User-ID and
+// X-User-Roles are never passed on; the gateway sets them from the
+// authenticated user so that upstreams can run in either auth mode. WebSocket upgrades are proxied as
+// is by httputil.ReverseProxy.
+func (s *Server) proxy(name string, target *url.URL, transport http.RoundTripper) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			setIdentity(pr.In.Context(), pr.Out.Header)
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			s.logger.Warn("upstream request failed",
+				"upstream", name,
+				"path", r.URL.Path,
+				"request_id", r.Header.Get(RequestIDHeader),
+				"err", err,
+			)
+			writeError(w, http.StatusBadGateway, name+" service unavailable")
+		},
+	}
+}
+
+func requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := auth.UserFrom(r.Context()); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type upstreamHealth struct {
+	Status string `json:"status"`
+	Code   int    `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	upstreams := map[string]*url.URL{"orders": s.orders, "payments": s.payments}
+	results := make(map[string]upstreamHealth, len(upstreams))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, base := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := upstreamHealth{Status: "ok"}
+			resp, err := s.get(r, base, "/healthz", nil)
+			if err != nil {
+				h = upstreamHealth{Status: "down", Error: err.Error()}
+			} else {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					h = upstreamHealth{Status: "degraded", Code: resp.StatusCode}
+				}
+			}
+			mu.Lock()
+			results[name] = h
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	code, state := http.StatusOK, "ok"
+	for _, h := range results {
+		if h.Status != "ok" {
+			code, state = http.StatusServiceUnavailable, "degraded"
+		}
+	}
+	writeJSON(w, code, map[string]any{"status": state, "upstreams": results})
+}
+
+// get calls an upstream on behalf of r, forwarding its credentials and
+// request ID.
+func (s *Server) get(r *http.Request, base *url.URL, path string, query url.Values) (*http.Response, error) {
+	u := base.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(RequestIDHeader, r.Header.Get(RequestIDHeader))
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		req.Header.Set("Authorization", authz)
+	}
+	setIdentity(r.Context(), req.Header)
+	return s.client.Do(req)
+}
+
+// setIdentity replaces any identity headers sent by the client with the
+// authenticated user and roles.
+func setIdentity(ctx context.Context, header http.Header) {
+	header.Del(auth.UserHeader)
+	header.Del(auth.RolesHeader)
+	if userID, err := auth.UserFrom(ctx); err == nil {
+		header.Set(auth.UserHeader, userID.String())
+		if roles := auth.RolesFrom(ctx); len(roles) > 0 {
+			header.Set(auth.RolesHeader, strings.Join(roles, ","))
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
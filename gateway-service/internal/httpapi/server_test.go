@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gozon/pkg/auth"
+
+	"github.com/google/uuid"
+)
+
+// echoUpstream answers with the identity headers and path it received.
+func echoUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"path":       r.URL.Path,
+			"user":       r.Header.Get(auth.UserHeader),
+			"roles":      r.Header.Get(auth.RolesHeader),
+			"request_id": r.Header.Get(RequestIDHeader),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestServer(t *testing.T, ordersURL, paymentsURL string) *Server {
+	t.Helper()
+	s, err := NewServer(ordersURL, paymentsURL, time.Second, 5, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestProxySetsIdentity(t *testing.T) {
+	orders, payments := echoUpstream(t), echoUpstream(t)
+	s := newTestServer(t, orders.URL, payments.URL)
+	user := uuid.New()
+
+	tests := []struct {
+		name      string
+		path      string
+		roles     []string
+		wantRoles string
+	}{
+		{name: "orders", path: "/orders/123"},
+		{name: "payments", path: "/accounts/balance"},
+		{name: "admin roles", path: "/orders", roles: []string{auth.RoleAdmin, "support"}, wantRoles: "admin,support"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			// A client must not be able to pick its own identity.
+			req.Header.Set(auth.UserHeader, uuid.NewString())
+			req.Header.Set(auth.RolesHeader, auth.RoleAdmin)
+			req.Header.Set(RequestIDHeader, "req-1")
+			ctx := auth.WithUser(req.Context(), user)
+			if len(tt.roles) > 0 {
+				ctx = auth.WithRoles(ctx, tt.roles...)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req.WithContext(ctx))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+			}
+			var got map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["path"] != tt.path || got["user"] != user.String() || got["roles"] != tt.wantRoles || got["request_id"] != "req-1" {
+				t.Errorf("upstream saw %v, want path %s user %s roles %q", got, tt.path, user, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestProxyRequiresUser(t *testing.T) {
+	upstream := echoUpstream(t)
+	s := newTestServer(t, upstream.URL, upstream.URL)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set(auth.UserHeader, uuid.NewString())
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("401 without WWW-Authenticate")
+	}
+}
+
+func TestProxyUpstreamDown(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	s := newTestServer(t, down.URL, down.URL)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req.WithContext(auth.WithUser(req.Context(), uuid.New())))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	healthy := echoUpstream(t)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	tests := []struct {
+		name     string
+		payments string
+		want     int
+		wantPay  string
+	}{
+		{name: "all up", payments: healthy.URL, want: http.StatusOK, wantPay: "ok"},
+		{name: "payments degraded", payments: failing.URL, want: http.StatusServiceUnavailable, wantPay: "degraded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, healthy.URL, tt.payments)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			var body struct {
+				Upstreams map[string]upstreamHealth `json:"upstreams"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Upstreams["orders"].Status != "ok" || body.Upstreams["payments"].Status != tt.wantPay {
+				t.Errorf("upstreams = %+v", body.Upstreams)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"gozon/pkg/auth"
+)
+
+type meSummary struct {
+	UserID  string            `json:"user_id"`
+	Balance json.RawMessage   `json:"balance"`
+	Orders  json.RawMessage   `json:"recent_orders"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// meSummary combines the caller's balance and most recent orders. A failing
+// upstream leaves its part null and is reported in errors; the request only
+// fails when both are unavailable.
+func (s *Server) meSummary(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.UserFrom(r.Context())
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var (
+		balance, orders       json.RawMessage
+		balanceErr, ordersErr error
+		wg                    sync.WaitGroup
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		balance, balanceErr = s.fetchBalance(r)
+	}()
+	go func() {
+		defer wg.Done()
+		orders, ordersErr = s.fetchRecentOrders(r)
+	}()
+	wg.Wait()
+
+	out := meSummary{
+		UserID:  userID.String(),
+		Balance: balance,
+		Orders:  orders,
+	}
+	if balanceErr != nil || ordersErr != nil {
+		out.Errors = make(map[string]string)
+	}
+	if balanceErr != nil {
+		out.Errors["balance"] = balanceErr.Error()
+		s.logger.Warn("summary balance failed", "request_id", r.Header.Get(RequestIDHeader), "err", balanceErr)
+	}
+	if ordersErr != nil {
+		out.Errors["recent_orders"] = ordersErr.Error()
+		s.logger.Warn("summary orders failed", "request_id", r.Header.Get(RequestIDHeader), "err", ordersErr)
+	}
+
+	if balanceErr != nil && ordersErr != nil {
+		writeJSON(w, http.StatusBadGateway, out)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// fetchBalance returns null for a user without an account yet.
+func (s *Server) fetchBalance(r *http.Request) (json.RawMessage, error) {
+	body, status, err := s.fetch(r, s.payments, "/accounts/balance", nil)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		return json.RawMessage("null"), nil
+	default:
+		return nil, fmt.Errorf("payments service returned %d", status)
+	}
+}
+
+func (s *Server) fetchRecentOrders(r *http.Request) (json.RawMessage, error) {
+	query := url.Values{"limit": {strconv.Itoa(s.summary)}}
+	body, status, err := s.fetch(r, s.orders, "/orders", query)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("orders service returned %d", status)
+	}
+
+	var page struct {
+		Orders json.RawMessage `json:"orders"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("decode orders: %w", err)
+	}
+	if len(page.Orders) == 0 {
+		return json.RawMessage("[]"), nil
+	}
+	return page.Orders, nil
+}
+
+func (s *Server) fetch(r *http.Request, base *url.URL, path string, query url.Values) ([]byte, int, error) {
+	resp, err := s.get(r, base, path, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, 0, fmt.Errorf("read response: %w", err)
+	}
+	return body, resp.StatusCode, nil
+}
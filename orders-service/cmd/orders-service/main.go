@@ -2,11 +2,19 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"gozon/orders-service/internal/app"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := app.Migrate(os.Args[2:]); err != nil {
+			log.Fatalf("migrate failed: %v", err)
+		}
+		return
+	}
+
 	if err := app.Run(); err != nil {
 		log.Fatalf("orders service failed: %v", err)
 	}
@@ -11,15 +11,17 @@ import (
 	"os/signal"
 	"syscall"
 
+	"gozon/orders-service/internal/catalog"
 	"gozon/orders-service/internal/config"
 	"gozon/orders-service/internal/httpapi"
 	"gozon/orders-service/internal/order"
 	"gozon/orders-service/internal/storage"
 	"gozon/orders-service/internal/websocket"
+	"gozon/pkg/auth"
 	"gozon/pkg/contracts"
 	"gozon/pkg/messaging"
-
-	"github.com/rabbitmq/amqp091-go"
+	"gozon/pkg/metrics"
+	"gozon/pkg/tracing"
 )
 
 type App struct {
@@ -30,11 +32,29 @@ type App struct {
 	wsHub     *websocket.Hub
 	publisher messaging.Publisher
 	outbox    *messaging.OutboxDispatcher
+	janitor   *messaging.OutboxJanitor
 	consumer  *messaging.Consumer
 	httpSrv   *http.Server
 }
 
+// publicRoutes are served without credentials.
+var publicRoutes = []string{"GET /healthz", "GET /metrics", "GET /products", "GET /products/{sku}"}
+
 func New(ctx context.Context, cfg config.Config, logger *slog.Logger) (*App, error) {
+	authn, err := auth.New(auth.Config{
+		Mode:     auth.Mode(cfg.AuthMode),
+		KeyFile:  cfg.AuthKeyFile,
+		JWKSFile: cfg.AuthJWKSFile,
+		Issuer:   cfg.AuthIssuer,
+		Audience: cfg.AuthAudience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init auth: %w", err)
+	}
+	if authn.Mode() == auth.ModeHeader {
+		logger.Warn("auth mode is header, X-User-ID is trusted as is; use for local development only")
+	}
+
 	store, err := storage.New(ctx, cfg.DatabaseURL)
 	if err != nil {
 		return nil, err
@@ -43,31 +63,55 @@ func New(ctx context.Context, cfg config.Config, logger *slog.Logger) (*App, err
 	wsHub := websocket.NewHub()
 
 	orderSvc := order.NewService(store.Pool(), wsHub)
+	catalogSvc := catalog.NewService(store.Pool())
+
+	encoding, err := messaging.ParseEncoding(cfg.EventEncoding)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
-	publisher, err := messaging.NewRabbitPublisher(cfg.RabbitURL, cfg.OrdersExchange)
+	publisher, err := messaging.NewRabbitPublisher(cfg.RabbitURL, cfg.OrdersExchange, cfg.OrdersExchangeKind, encoding, logger)
 	if err != nil {
 		store.Close()
 		return nil, err
 	}
 
-	consumer, err := messaging.NewRabbitConsumer(cfg.RabbitURL, cfg.PaymentsExchange, cfg.PaymentsQueue, logger)
+	consumer, err := messaging.NewRabbitConsumer(cfg.RabbitURL, cfg.PaymentsExchange, cfg.PaymentsExchangeKind, cfg.PaymentsQueue, messaging.ConsumerOptions{
+		Bindings: cfg.PaymentsBindings,
+		Retry: messaging.RetryPolicy{
+			MaxAttempts: cfg.ConsumerMaxAttempts,
+			Delays:      cfg.ConsumerRetryDelays,
+		},
+		Workers: cfg.ConsumerWorkers,
+		Key:     messaging.DataKey("order_id"),
+	}, logger)
 	if err != nil {
 		store.Close()
 		publisher.Close()
 		return nil, err
 	}
 
-	api := httpapi.NewServer(orderSvc, logger)
+	deadLetters := messaging.NewDeadLetterAdmin(cfg.RabbitURL, cfg.PaymentsQueue, store.Pool(), "order_dead_letter_audit", contracts.Decode, logger)
+
+	api := httpapi.NewServer(orderSvc, catalogSvc, deadLetters, logger)
 	wsHandler := websocket.NewHandler(wsHub, orderSvc)
 	api.HandleFunc("GET /orders/{orderID}/ws", wsHandler.ServeWS)
 	httpSrv := &http.Server{
 		Addr:    cfg.HTTPAddr,
-		Handler: api,
+		Handler: authn.Middleware(tracing.Middleware(metrics.InstrumentMux(api)), publicRoutes...),
 	}
 
-	outbox := messaging.NewOutboxDispatcher(store.Pool(), publisher, "order_outbox", cfg.OutboxInterval, cfg.OutboxBatchSize, logger)
+	outbox := messaging.NewOutboxDispatcher(store.Pool(), publisher, "order_outbox", cfg.OutboxInterval, cfg.OutboxBatchSize, cfg.OutboxMaxAttempts, cfg.OutboxLease, logger)
+	janitor := messaging.NewOutboxJanitor(store.Pool(), "order_outbox", messaging.InboxTable{Name: "order_inbox", TimeColumn: "received_at"}, messaging.RetentionPolicy{
+		Interval:       cfg.JanitorInterval,
+		SentRetention:  cfg.OutboxRetention,
+		InboxRetention: cfg.InboxRetention,
+		BatchSize:      cfg.JanitorBatchSize,
+		Archive:        cfg.OutboxArchive,
+	}, logger)
 
-	return &App{
+	app := &App{
 		cfg:       cfg,
 		logger:    logger,
 		store:     store,
@@ -76,8 +120,13 @@ func New(ctx context.Context, cfg config.Config, logger *slog.Logger) (*App, err
 		publisher: publisher,
 		consumer:  consumer,
 		outbox:    outbox,
+		janitor:   janitor,
 		httpSrv:   httpSrv,
-	}, nil
+	}
+	api.HandleFunc("GET /healthz", app.healthz)
+	api.HandleFunc("GET /metrics", metrics.Handler().ServeHTTP)
+
+	return app, nil
 }
 
 func (a *App) Run(ctx context.Context) error {
@@ -87,6 +136,7 @@ func (a *App) Run(ctx context.Context) error {
 	errCh := make(chan error, 2)
 
 	a.outbox.Start(ctx)
+	go a.janitor.Run(ctx)
 
 	go a.wsHub.Run(ctx)
 
@@ -113,26 +163,83 @@ func (a *App) Close(ctx context.Context) {
 	shutdownCtx, cancel := context.WithTimeout(ctx, a.cfg.ShutdownGracePeriod)
 	defer cancel()
 	_ = a.httpSrv.Shutdown(shutdownCtx)
-	a.consumer.Close()
+	a.consumer.Close(shutdownCtx)
 	a.publisher.Close()
 	a.store.Close()
 }
 
-func (a *App) handlePaymentMessage(ctx context.Context, msg amqp091.Delivery) {
+func (a *App) handlePaymentMessage(ctx context.Context, env contracts.Envelope) error {
+	switch env.Type {
+	case contracts.EventPaymentProcessed:
+		return a.handleProcessedMessage(ctx, env)
+	case contracts.EventPaymentRefunded:
+		return a.handleRefundMessage(ctx, env)
+	case contracts.EventPaymentExpired:
+		return a.handleExpiredMessage(ctx, env)
+	}
+	return messaging.Permanent(fmt.Errorf("unexpected event type %q", env.Type))
+}
+
+func (a *App) handleProcessedMessage(ctx context.Context, env contracts.Envelope) error {
 	var evt contracts.PaymentProcessedEvent
-	if err := json.Unmarshal(msg.Body, &evt); err != nil {
-		a.logger.Error("invalid payment event", "err", err)
-		_ = msg.Nack(false, false)
-		return
+	if err := json.Unmarshal(env.Data, &evt); err != nil {
+		return messaging.Permanent(fmt.Errorf("decode payment event: %w", err))
 	}
 
 	if err := a.orderSvc.ApplyPaymentResult(ctx, evt); err != nil {
-		a.logger.Error("apply payment result failed", "order_id", evt.OrderID, "err", err)
-		_ = msg.Nack(false, true)
-		return
+		if errors.Is(err, order.ErrInvalidTransition) {
+			a.logger.Warn("payment result rejected", "order_id", evt.OrderID, "err", err)
+			return nil
+		}
+		return fmt.Errorf("apply payment result: %w", err)
 	}
+	return nil
+}
 
-	_ = msg.Ack(false)
+func (a *App) handleRefundMessage(ctx context.Context, env contracts.Envelope) error {
+	var evt contracts.PaymentRefundedEvent
+	if err := json.Unmarshal(env.Data, &evt); err != nil {
+		return messaging.Permanent(fmt.Errorf("decode refund event: %w", err))
+	}
+
+	if err := a.orderSvc.ApplyRefund(ctx, evt); err != nil {
+		if errors.Is(err, order.ErrInvalidTransition) {
+			a.logger.Warn("refund rejected", "order_id", evt.OrderID, "err", err)
+			return nil
+		}
+		return fmt.Errorf("apply refund: %w", err)
+	}
+	return nil
+}
+
+func (a *App) handleExpiredMessage(ctx context.Context, env contracts.Envelope) error {
+	var evt contracts.PaymentExpiredEvent
+	if err := json.Unmarshal(env.Data, &evt); err != nil {
+		return messaging.Permanent(fmt.Errorf("decode payment expired event: %w", err))
+	}
+
+	if err := a.orderSvc.ApplyPaymentExpired(ctx, evt); err != nil {
+		if errors.Is(err, order.ErrInvalidTransition) {
+			a.logger.Warn("payment expiry rejected", "order_id", evt.OrderID, "err", err)
+			return nil
+		}
+		return fmt.Errorf("apply payment expiry: %w", err)
+	}
+	return nil
+}
+
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	status := a.consumer.Status()
+	code := http.StatusOK
+	state := "ok"
+	if !a.consumer.Healthy() {
+		code = http.StatusServiceUnavailable
+		state = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": state, "consumer": status})
 }
 
 func Run() error {
@@ -141,6 +248,20 @@ func Run() error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: contracts.ProducerOrders,
+		Exporter:    cfg.TracingExporter,
+		File:        cfg.TracingFile,
+	})
+	if err != nil {
+		return fmt.Errorf("init tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.WithoutCancel(ctx)); err != nil {
+			logger.Warn("flush traces failed", "err", err)
+		}
+	}()
+
 	app, err := New(ctx, cfg, logger)
 	if err != nil {
 		return fmt.Errorf("init app: %w", err)
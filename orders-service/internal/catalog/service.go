@@ -0,0 +1,169 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrProductExists   = errors.New("product already exists")
+	ErrInvalidProduct  = errors.New("invalid product")
+)
+
+type Product struct {
+	SKU       string    `json:"sku"`
+	Name      string    `json:"name"`
+	Price     int64     `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DB is the part of *pgxpool.Pool the service uses.
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type Service struct {
+	pool DB
+}
+
+func NewService(pool DB) *Service {
+	return &Service{pool: pool}
+}
+
+func validate(sku, name string, price int64) error {
+	if strings.TrimSpace(sku) == "" {
+		return fmt.Errorf("%w: sku is required", ErrInvalidProduct)
+	}
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidProduct)
+	}
+	if price <= 0 {
+		return fmt.Errorf("%w: price must be positive", ErrInvalidProduct)
+	}
+	return nil
+}
+
+func (s *Service) Create(ctx context.Context, sku, name string, price int64) (*Product, error) {
+	if err := validate(sku, name, price); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO products (sku, name, price, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)`,
+		sku, name, price, now,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrProductExists
+		}
+		return nil, fmt.Errorf("create product: %w", err)
+	}
+	return &Product{SKU: sku, Name: name, Price: price, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+func (s *Service) Update(ctx context.Context, sku, name string, price int64) (*Product, error) {
+	if err := validate(sku, name, price); err != nil {
+		return nil, err
+	}
+
+	var p Product
+	err := s.pool.QueryRow(ctx, `
+		UPDATE products
+		SET name = $2, price = $3, updated_at = NOW()
+		WHERE sku = $1
+		RETURNING sku, name, price, created_at, updated_at`,
+		sku, name, price,
+	).Scan(&p.SKU, &p.Name, &p.Price, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, fmt.Errorf("update product: %w", err)
+	}
+	return &p, nil
+}
+
+func (s *Service) Delete(ctx context.Context, sku string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM products WHERE sku = $1`, sku)
+	if err != nil {
+		return fmt.Errorf("delete product: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+func (s *Service) Get(ctx context.Context, sku string) (*Product, error) {
+	var p Product
+	err := s.pool.QueryRow(ctx, `
+		SELECT sku, name, price, created_at, updated_at
+		FROM products
+		WHERE sku = $1`,
+		sku,
+	).Scan(&p.SKU, &p.Name, &p.Price, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, fmt.Errorf("get product: %w", err)
+	}
+	return &p, nil
+}
+
+func (s *Service) List(ctx context.Context) ([]Product, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT sku, name, price, created_at, updated_at
+		FROM products
+		ORDER BY sku`)
+	if err != nil {
+		return nil, fmt.Errorf("query products: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Product{}
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.SKU, &p.Name, &p.Price, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func Lookup(ctx context.Context, tx pgx.Tx, skus []string) (map[string]Product, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT sku, name, price, created_at, updated_at
+		FROM products
+		WHERE sku = ANY($1)`,
+		skus,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("lookup products: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]Product, len(skus))
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.SKU, &p.Name, &p.Price, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result[p.SKU] = p
+	}
+	return result, rows.Err()
+}
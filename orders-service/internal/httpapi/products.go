@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gozon/orders-service/internal/catalog"
+)
+
+type productRequest struct {
+	SKU   string `json:"sku"`
+	Name  string `json:"name"`
+	Price int64  `json:"price"`
+}
+
+func (s *Server) createProduct(w http.ResponseWriter, r *http.Request) {
+	var req productRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	p, err := s.catalogSvc.Create(r.Context(), req.SKU, req.Name, req.Price)
+	if err != nil {
+		switch {
+		case errors.Is(err, catalog.ErrProductExists):
+			writeError(w, http.StatusConflict, "product already exists")
+		case errors.Is(err, catalog.ErrInvalidProduct):
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			s.logger.Error("create product", "err", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (s *Server) listProducts(w http.ResponseWriter, r *http.Request) {
+	products, err := s.catalogSvc.List(r.Context())
+	if err != nil {
+		s.logger.Error("list products", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"products": products})
+}
+
+func (s *Server) getProduct(w http.ResponseWriter, r *http.Request) {
+	p, err := s.catalogSvc.Get(r.Context(), r.PathValue("sku"))
+	if err != nil {
+		if errors.Is(err, catalog.ErrProductNotFound) {
+			writeError(w, http.StatusNotFound, "product not found")
+			return
+		}
+		s.logger.Error("get product", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) updateProduct(w http.ResponseWriter, r *http.Request) {
+	var req productRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	p, err := s.catalogSvc.Update(r.Context(), r.PathValue("sku"), req.Name, req.Price)
+	if err != nil {
+		switch {
+		case errors.Is(err, catalog.ErrProductNotFound):
+			writeError(w, http.StatusNotFound, "product not found")
+		case errors.Is(err, catalog.ErrInvalidProduct):
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			s.logger.Error("update product", "err", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) deleteProduct(w http.ResponseWriter, r *http.Request) {
+	if err := s.catalogSvc.Delete(r.Context(), r.PathValue("sku")); err != nil {
+		if errors.Is(err, catalog.ErrProductNotFound) {
+			writeError(w, http.StatusNotFound, "product not found")
+			return
+		}
+		s.logger.Error("delete product", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gozon/pkg/auth"
+	"gozon/pkg/pgtest"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestProductHandlers(t *testing.T) {
+	now := time.Now()
+	book := func([]any) pgtest.Result { return pgtest.Row("book-001", "Book", int64(500), now, now) }
+	none := func([]any) pgtest.Result { return pgtest.Rows() }
+	affected := func(n int64) pgtest.Handler {
+		return func([]any) pgtest.Result { return pgtest.Affected(n) }
+	}
+	fail := func(err error) pgtest.Handler {
+		return func([]any) pgtest.Result { return pgtest.Fail(err) }
+	}
+
+	const valid = `{"sku":"book-001","name":"Book","price":500}`
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		user    bool
+		roles   []string
+		db      map[string]pgtest.Handler
+		want    int
+		wantMsg string
+	}{
+		{name: "create anonymous", method: http.MethodPost, path: "/products", body: valid, want: http.StatusUnauthorized},
+		{name: "create without admin", method: http.MethodPost, path: "/products", body: valid, user: true, roles: []string{"support"}, want: http.StatusForbidden},
+		{name: "update without admin", method: http.MethodPut, path: "/products/book-001", body: valid, user: true, want: http.StatusForbidden},
+		{name: "delete anonymous", method: http.MethodDelete, path: "/products/book-001", want: http.StatusUnauthorized},
+		{
+			name: "create", method: http.MethodPost, path: "/products", body: valid, user: true, roles: []string{auth.RoleAdmin},
+			db:   map[string]pgtest.Handler{"INSERT INTO products": affected(1)},
+			want: http.StatusCreated,
+		},
+		{name: "create invalid json", method: http.MethodPost, path: "/products", body: `{"sku":`, user: true, roles: []string{auth.RoleAdmin}, want: http.StatusBadRequest, wantMsg: "invalid JSON body"},
+		{name: "create without sku", method: http.MethodPost, path: "/products", body: `{"name":"Book","price":500}`, user: true, roles: []string{auth.RoleAdmin}, want: http.StatusBadRequest, wantMsg: "sku is required"},
+		{name: "create with zero price", method: http.MethodPost, path: "/products", body: `{"sku":"b","name":"Book"}`, user: true, roles: []string{auth.RoleAdmin}, want: http.StatusBadRequest, wantMsg: "price must be positive"},
+		{
+			name: "create duplicate", method: http.MethodPost, path: "/products", body: valid, user: true, roles: []string{auth.RoleAdmin},
+			db:   map[string]pgtest.Handler{"INSERT INTO products": fail(&pgconn.PgError{Code: "23505"})},
+			want: http.StatusConflict,
+		},
+		{
+			name: "create database error", method: http.MethodPost, path: "/products", body: valid, user: true, roles: []string{auth.RoleAdmin},
+			db:   map[string]pgtest.Handler{"INSERT INTO products": fail(errors.New("connection reset by peer"))},
+			want: http.StatusInternalServerError, wantMsg: "internal error",
+		},
+		{
+			name: "update", method: http.MethodPut, path: "/products/book-001", body: valid, user: true, roles: []string{auth.RoleAdmin},
+			db:   map[string]pgtest.Handler{"UPDATE products": book},
+			want: http.StatusOK,
+		},
+		{
+			name: "update missing", method: http.MethodPut, path: "/products/nope", body: valid, user: true, roles: []string{auth.RoleAdmin},
+			db:   map[string]pgtest.Handler{"UPDATE products": none},
+			want: http.StatusNotFound,
+		},
+		{name: "update without name", method: http.MethodPut, path: "/products/book-001", body: `{"price":500}`, user: true, roles: []string{auth.RoleAdmin}, want: http.StatusBadRequest, wantMsg: "name is required"},
+		{
+			name: "delete", method: http.MethodDelete, path: "/products/book-001", user: true, roles: []string{auth.RoleAdmin},
+			db:   map[string]pgtest.Handler{"DELETE FROM products": affected(1)},
+			want: http.StatusNoContent,
+		},
+		{
+			name: "delete missing", method: http.MethodDelete, path: "/products/nope", user: true, roles: []string{auth.RoleAdmin},
+			db:   map[string]pgtest.Handler{"DELETE FROM products": affected(0)},
+			want: http.StatusNotFound,
+		},
+		{name: "get", method: http.MethodGet, path: "/products/book-001", db: map[string]pgtest.Handler{"FROM products WHERE sku": book}, want: http.StatusOK},
+		{name: "get missing", method: http.MethodGet, path: "/products/nope", db: map[string]pgtest.Handler{"FROM products WHERE sku": none}, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := pgtest.New()
+			for fragment, h := range tt.db {
+				db.On(fragment, h)
+			}
+			s := newTestServer(db)
+
+			var rec *httptest.ResponseRecorder
+			if tt.user {
+				rec = do(s, tt.method, tt.path, tt.body, tt.roles...)
+			} else {
+				rec = httptest.NewRecorder()
+				s.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			}
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantMsg) {
+				t.Errorf("body = %s, want it to mention %q", rec.Body.String(), tt.wantMsg)
+			}
+			if tt.want == http.StatusUnauthorized || tt.want == http.StatusForbidden || tt.want == http.StatusBadRequest {
+				if calls := db.Calls("products"); len(calls) != 0 {
+					t.Errorf("rejected request reached the database: %v", calls)
+				}
+			}
+		})
+	}
+}
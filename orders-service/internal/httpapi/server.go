@@ -4,25 +4,39 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"gozon/orders-service/internal/catalog"
 	"gozon/orders-service/internal/order"
+	"gozon/pkg/auth"
+	"gozon/pkg/idempotency"
+	"gozon/pkg/messaging"
+	"gozon/pkg/messaging/dlqhttp"
 
 	"github.com/google/uuid"
 )
 
 type Server struct {
-	orderSvc *order.Service
-	logger   *slog.Logger
-	mux      *http.ServeMux
+	orderSvc    *order.Service
+	catalogSvc  *catalog.Service
+	deadLetters *dlqhttp.Handler
+	logger      *slog.Logger
+	mux         *http.ServeMux
 }
 
-func NewServer(orderSvc *order.Service, logger *slog.Logger) *Server {
+func NewServer(orderSvc *order.Service, catalogSvc *catalog.Service, deadLetters *messaging.DeadLetterAdmin, logger *slog.Logger) *Server {
 	s := &Server{
-		orderSvc: orderSvc,
-		logger:   logger,
-		mux:      http.NewServeMux(),
+		orderSvc:    orderSvc,
+		catalogSvc:  catalogSvc,
+		deadLetters: dlqhttp.New(deadLetters, logger),
+		logger:      logger,
+		mux:         http.NewServeMux(),
 	}
 
 	s.routes()
@@ -33,6 +47,15 @@ func (s *Server) routes() {
 	s.mux.HandleFunc("POST /orders", s.createOrder)
 	s.mux.HandleFunc("GET /orders", s.listOrders)
 	s.mux.HandleFunc("GET /orders/{orderID}", s.getOrder)
+	s.mux.HandleFunc("POST /orders/{orderID}/cancel", s.cancelOrder)
+	s.mux.HandleFunc("POST /orders/{orderID}/complete", s.completeOrder)
+	s.mux.HandleFunc("GET /orders/{orderID}/history", s.orderHistory)
+	s.mux.Handle("POST /products", auth.RequireAdmin(http.HandlerFunc(s.createProduct)))
+	s.mux.HandleFunc("GET /products", s.listProducts)
+	s.mux.HandleFunc("GET /products/{sku}", s.getProduct)
+	s.mux.Handle("PUT /products/{sku}", auth.RequireAdmin(http.HandlerFunc(s.updateProduct)))
+	s.mux.Handle("DELETE /products/{sku}", auth.RequireAdmin(http.HandlerFunc(s.deleteProduct)))
+	s.deadLetters.Register(s.mux, auth.RequireAdmin)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -45,50 +68,153 @@ func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *h
 
 func (s *Server) createOrder(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	idem, err := idempotency.FromRequest(r, userID.String(), body)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	var req struct {
-		Amount int64 `json:"amount"`
+		Items []order.ItemInput `json:"items"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid JSON body")
 		return
 	}
 
-	order, err := s.orderSvc.Create(r.Context(), userID, req.Amount)
+	order, err := s.orderSvc.Create(r.Context(), userID, req.Items, idem)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		switch {
+		case errors.Is(err, idempotency.ErrKeyReused):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		case isInvalidOrder(err):
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			s.logger.Error("create order", "err", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
 		return
 	}
 
 	writeJSON(w, http.StatusCreated, order)
 }
 
+// isInvalidOrder reports whether Create rejected the request itself, as
+// opposed to failing on the way to the database.
+func isInvalidOrder(err error) bool {
+	for _, target := range []error{order.ErrInvalidItems, order.ErrInvalidQuantity, order.ErrAmountOverflow, order.ErrUnknownProduct} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	filter, err := parseListFilter(r.URL.Query())
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	orders, err := s.orderSvc.List(r.Context(), userID)
+	page, err := s.orderSvc.List(r.Context(), userID, filter)
 	if err != nil {
+		if errors.Is(err, order.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
 		s.logger.Error("list orders", "err", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{"orders": orders})
+	var nextCursor any
+	if page.NextCursor != "" {
+		nextCursor = page.NextCursor
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"orders": page.Orders, "next_cursor": nextCursor})
+}
+
+func parseListFilter(q url.Values) (order.ListFilter, error) {
+	filter := order.ListFilter{Cursor: q.Get("cursor")}
+
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return filter, errors.New("invalid limit")
+		}
+		filter.Limit = limit
+	}
+	if raw := q.Get("status"); raw != "" {
+		status := order.Status(raw)
+		if !status.Valid() {
+			return filter, errors.New("invalid status")
+		}
+		filter.Status = status
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseTimeParam(q, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseTimeParam(q, "created_before"); err != nil {
+		return filter, err
+	}
+	if filter.MinAmount, err = parseIntParam(q, "min_amount"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAmount, err = parseIntParam(q, "max_amount"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseTimeParam(q url.Values, key string) (*time.Time, error) {
+	raw := q.Get(key)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: expected RFC 3339 timestamp", key)
+	}
+	return &t, nil
+}
+
+func parseIntParam(q url.Values, key string) (*int64, error) {
+	raw := q.Get(key)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", key)
+	}
+	return &v, nil
 }
 
 func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.userIDFromRequest(r)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
@@ -113,12 +239,73 @@ func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, o)
 }
 
-func (s *Server) userIDFromRequest(r *http.Request) (uuid.UUID, error) {
-	value := r.Header.Get("X-User-ID")
-	if value == "" {
-		return uuid.UUID{}, errors.New("missing X-User-ID header")
+func (s *Server) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	s.changeOrderStatus(w, r, s.orderSvc.Cancel)
+}
+
+func (s *Server) completeOrder(w http.ResponseWriter, r *http.Request) {
+	s.changeOrderStatus(w, r, s.orderSvc.Complete)
+}
+
+func (s *Server) changeOrderStatus(w http.ResponseWriter, r *http.Request, change func(context.Context, uuid.UUID, uuid.UUID) (*order.Order, error)) {
+	userID, err := s.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	orderID, err := uuid.Parse(r.PathValue("orderID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+
+	o, err := change(r.Context(), userID, orderID)
+	if err != nil {
+		switch {
+		case errors.Is(err, order.ErrOrderNotFound):
+			writeError(w, http.StatusNotFound, "order not found")
+		case errors.Is(err, order.ErrInvalidTransition):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			s.logger.Error("change order status", "order_id", orderID.String(), "err", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) orderHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	orderID, err := uuid.Parse(r.PathValue("orderID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+
+	history, err := s.orderSvc.History(r.Context(), userID, orderID)
+	if err != nil {
+		if errors.Is(err, order.ErrOrderNotFound) {
+			writeError(w, http.StatusNotFound, "order not found")
+			return
+		}
+		s.logger.Error("order history", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
 	}
-	return uuid.Parse(value)
+
+	writeJSON(w, http.StatusOK, map[string]any{"order_id": orderID.String(), "history": history})
+}
+
+func (s *Server) userIDFromRequest(r *http.Request) (uuid.UUID, error) {
+	return auth.UserFrom(r.Context())
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -0,0 +1,156 @@
+package httpapi
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gozon/orders-service/internal/catalog"
+	"gozon/orders-service/internal/order"
+	"gozon/pkg/auth"
+	"gozon/pkg/pgtest"
+
+	"github.com/google/uuid"
+)
+
+func TestParseListFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		check   func(t *testing.T, f order.ListFilter)
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			query: "",
+			check: func(t *testing.T, f order.ListFilter) {
+				if f.Limit != 0 || f.Status != "" || f.Cursor != "" || f.CreatedAfter != nil || f.MinAmount != nil {
+					t.Errorf("filter = %+v, want zero", f)
+				}
+			},
+		},
+		{
+			name:  "all parameters",
+			query: "limit=10&status=paid&cursor=abc&created_after=2024-01-01T00:00:00Z&created_before=2024-02-01T00:00:00Z&min_amount=5&max_amount=50",
+			check: func(t *testing.T, f order.ListFilter) {
+				if f.Limit != 10 || f.Status != order.StatusPaid || f.Cursor != "abc" {
+					t.Errorf("filter = %+v", f)
+				}
+				if f.CreatedAfter == nil || f.CreatedAfter.Month() != 1 || f.CreatedBefore == nil || f.CreatedBefore.Month() != 2 {
+					t.Errorf("created range = %v..%v", f.CreatedAfter, f.CreatedBefore)
+				}
+				if f.MinAmount == nil || *f.MinAmount != 5 || f.MaxAmount == nil || *f.MaxAmount != 50 {
+					t.Errorf("amount range = %v..%v", f.MinAmount, f.MaxAmount)
+				}
+			},
+		},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "negative limit", query: "limit=-1", wantErr: true},
+		{name: "non-numeric limit", query: "limit=ten", wantErr: true},
+		{name: "unknown status", query: "status=shipped", wantErr: true},
+		{name: "bad timestamp", query: "created_after=2024-01-01", wantErr: true},
+		{name: "bad amount", query: "min_amount=1.5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := parseListFilter(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseListFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, f)
+			}
+		})
+	}
+}
+
+func newTestServer(db *pgtest.DB) *Server {
+	return NewServer(order.NewService(db, nil), catalog.NewService(db), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// do serves a request on behalf of a signed-in user with the given roles.
+func do(s *Server, method, path, body string, roles ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	ctx := auth.WithUser(req.Context(), uuid.New())
+	if len(roles) > 0 {
+		ctx = auth.WithRoles(ctx, roles...)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+func TestCreateOrderErrors(t *testing.T) {
+	now := time.Now()
+	products := func(price int64) pgtest.Handler {
+		return func([]any) pgtest.Result {
+			return pgtest.Row("sku-1", "Widget", price, now, now)
+		}
+	}
+	tests := []struct {
+		name    string
+		body    string
+		lookup  pgtest.Handler
+		want    int
+		wantMsg string
+	}{
+		{name: "created", body: `{"items":[{"sku":"sku-1","quantity":2}]}`, lookup: products(100), want: http.StatusCreated},
+		{name: "invalid json", body: `{"items":`, want: http.StatusBadRequest, wantMsg: "invalid JSON body"},
+		{name: "no items", body: `{"items":[]}`, want: http.StatusBadRequest, wantMsg: "at least one item"},
+		{name: "zero quantity", body: `{"items":[{"sku":"sku-1","quantity":0}]}`, want: http.StatusBadRequest, wantMsg: "invalid item quantity"},
+		{
+			name:    "unknown product",
+			body:    `{"items":[{"sku":"sku-9","quantity":1}]}`,
+			lookup:  func([]any) pgtest.Result { return pgtest.Rows() },
+			want:    http.StatusBadRequest,
+			wantMsg: "unknown product: sku-9",
+		},
+		{
+			name:    "amount overflow",
+			body:    `{"items":[{"sku":"sku-1","quantity":2}]}`,
+			lookup:  products(math.MaxInt64),
+			want:    http.StatusBadRequest,
+			wantMsg: "too large",
+		},
+		{
+			name:    "database error",
+			body:    `{"items":[{"sku":"sku-1","quantity":1}]}`,
+			lookup:  func([]any) pgtest.Result { return pgtest.Fail(errors.New("connection reset by peer")) },
+			want:    http.StatusInternalServerError,
+			wantMsg: "internal error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := pgtest.New()
+			if tt.lookup != nil {
+				db.On("FROM products", tt.lookup)
+			}
+			for _, table := range []string{"orders", "order_items", "order_status_history", "order_outbox"} {
+				db.On("INSERT INTO "+table+" (", func([]any) pgtest.Result { return pgtest.Affected(1) })
+			}
+
+			rec := do(newTestServer(db), http.MethodPost, "/orders", tt.body)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantMsg) {
+				t.Errorf("body = %s, want it to mention %q", rec.Body.String(), tt.wantMsg)
+			}
+			if strings.Contains(rec.Body.String(), "connection reset") {
+				t.Errorf("body %s leaks the database error", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package order
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var paidLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "order_created_to_paid_seconds",
+	Help:    "Time from order creation until the payment result marks it paid.",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+})
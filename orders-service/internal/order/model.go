@@ -7,16 +7,47 @@ import (
 type Status string
 
 const (
-	StatusPending Status = "pending"
-	StatusPaid    Status = "paid"
-	StatusFailed  Status = "failed"
+	StatusPending   Status = "pending"
+	StatusPaid      Status = "paid"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+	StatusRefunded  Status = "refunded"
+	StatusCompleted Status = "completed"
 )
 
+func (s Status) Valid() bool {
+	switch s {
+	case StatusPending, StatusPaid, StatusFailed, StatusCancelled, StatusRefunded, StatusCompleted:
+		return true
+	}
+	return false
+}
+
+type Item struct {
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"`
+}
+
+type ItemInput struct {
+	SKU      string `json:"sku"`
+	Quantity int    `json:"quantity"`
+}
+
 type Order struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
 	Amount    int64     `json:"amount"`
 	Status    Status    `json:"status"`
+	Items     []Item    `json:"items,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+type StatusChange struct {
+	From      Status    `json:"from,omitempty"`
+	To        Status    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,62 @@
+package order
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ListFilter struct {
+	Status        Status
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	MinAmount     *int64
+	MaxAmount     *int64
+	Limit         int
+	Cursor        string
+}
+
+type Page struct {
+	Orders     []Order
+	NextCursor string
+}
+
+type cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func encodeCursor(o Order) string {
+	raw := o.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + o.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(value string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return cursor{}, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	orderID, err := uuid.Parse(id)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{CreatedAt: createdAt, ID: orderID}, nil
+}
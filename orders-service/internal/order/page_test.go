@@ -0,0 +1,56 @@
+package order
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name      string
+		createdAt time.Time
+	}{
+		{name: "utc", createdAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "nanoseconds survive", createdAt: time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)},
+		{name: "local time is normalised", createdAt: time.Date(2024, 5, 1, 15, 0, 0, 0, time.FixedZone("MSK", 3*3600))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := decodeCursor(encodeCursor(Order{ID: id.String(), CreatedAt: tt.createdAt}))
+			if err != nil {
+				t.Fatalf("decodeCursor() error = %v", err)
+			}
+			if !c.CreatedAt.Equal(tt.createdAt) {
+				t.Errorf("CreatedAt = %s, want %s", c.CreatedAt, tt.createdAt)
+			}
+			if c.ID != id {
+				t.Errorf("ID = %s, want %s", c.ID, id)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "not base64", value: "!!!"},
+		{name: "no separator", value: enc("2024-05-01T12:00:00Z")},
+		{name: "bad timestamp", value: enc("yesterday|" + uuid.NewString())},
+		{name: "bad id", value: enc("2024-05-01T12:00:00Z|42")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.value); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidCursor", tt.value, err)
+			}
+		})
+	}
+}
@@ -5,35 +5,68 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
+	"gozon/orders-service/internal/catalog"
 	"gozon/pkg/contracts"
+	"gozon/pkg/idempotency"
+	"gozon/pkg/messaging"
+	"gozon/pkg/tracing"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	ErrOrderNotFound = errors.New("order not found")
+	ErrOrderNotFound   = errors.New("order not found")
+	ErrUnknownProduct  = errors.New("unknown product")
+	ErrAmountOverflow  = errors.New("order amount is too large")
+	ErrInvalidQuantity = errors.New("invalid item quantity")
+	ErrInvalidItems    = errors.New("invalid order items")
 )
 
+// MaxItemQuantity caps the quantity of a single SKU in an order, after
+// duplicate lines are merged.
+const MaxItemQuantity = 10000
+
+const (
+	idempotencyTable = "order_idempotency"
+	outboxTable      = "order_outbox"
+)
+
+// DB is the part of *pgxpool.Pool the service uses.
+type DB interface {
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type Service struct {
-	pool        *pgxpool.Pool
+	pool        DB
 	broadcaster interface {
 		BroadcastOrderUpdate(orderID string, status string)
 	}
 }
 
-func NewService(pool *pgxpool.Pool, broadcaster interface {
+func NewService(pool DB, broadcaster interface {
 	BroadcastOrderUpdate(orderID string, status string)
 }) *Service {
 	return &Service{pool: pool, broadcaster: broadcaster}
 }
 
-func (s *Service) Create(ctx context.Context, userID uuid.UUID, amount int64) (*Order, error) {
-	if amount <= 0 {
-		return nil, fmt.Errorf("amount must be positive")
+func (s *Service) Create(ctx context.Context, userID uuid.UUID, items []ItemInput, idem *idempotency.Key) (_ *Order, err error) {
+	ctx, span := tracer.Start(ctx, "order.Create", trace.WithAttributes(attribute.String("user.id", userID.String())))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+	quantities, skus, err := mergeItems(items)
+	if err != nil {
+		return nil, err
 	}
 
 	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
@@ -42,6 +75,39 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID, amount int64) (*
 	}
 	defer tx.Rollback(ctx)
 
+	if idem != nil {
+		stored, err := idempotency.Acquire(ctx, tx, idempotencyTable, *idem)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			var replay Order
+			if err := json.Unmarshal(stored, &replay); err != nil {
+				return nil, fmt.Errorf("decode stored order: %w", err)
+			}
+			return &replay, tx.Commit(ctx)
+		}
+	}
+
+	products, err := catalog.Lookup(ctx, tx, skus)
+	if err != nil {
+		return nil, err
+	}
+
+	var amount int64
+	lines := make([]Item, 0, len(skus))
+	for _, sku := range skus {
+		p, ok := products[sku]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownProduct, sku)
+		}
+		line := Item{SKU: sku, Name: p.Name, Quantity: quantities[sku], UnitPrice: p.Price}
+		if amount, err = addLine(amount, line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
 	now := time.Now().UTC()
 	orderID := uuid.New()
 	order := &Order{
@@ -49,6 +115,7 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID, amount int64) (*
 		UserID:    userID.String(),
 		Amount:    amount,
 		Status:    StatusPending,
+		Items:     lines,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
@@ -62,26 +129,44 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID, amount int64) (*
 		return nil, fmt.Errorf("insert order: %w", err)
 	}
 
+	eventItems := make([]contracts.OrderItem, 0, len(lines))
+	for _, line := range lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO order_items (order_id, sku, name, quantity, unit_price)
+			VALUES ($1, $2, $3, $4, $5)`,
+			orderID, line.SKU, line.Name, line.Quantity, line.UnitPrice,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("insert order item: %w", err)
+		}
+		eventItems = append(eventItems, contracts.OrderItem(line))
+	}
+
+	if err := recordTransition(ctx, tx, orderID, "", StatusPending, ""); err != nil {
+		return nil, err
+	}
+
 	event := contracts.OrderCreatedEvent{
 		EventID:   uuid.New().String(),
 		OrderID:   orderID.String(),
 		UserID:    userID.String(),
 		Amount:    amount,
+		Items:     eventItems,
 		CreatedAt: now,
 	}
 
-	payload, err := json.Marshal(event)
+	env, err := contracts.NewEnvelope(ctx, contracts.ProducerOrders, contracts.EventOrderCreated, event.EventID, event)
 	if err != nil {
-		return nil, fmt.Errorf("marshal event: %w", err)
+		return nil, err
+	}
+	if err := messaging.InsertOutbox(ctx, tx, outboxTable, env); err != nil {
+		return nil, err
 	}
 
-	_, err = tx.Exec(ctx, `
-		INSERT INTO order_outbox (event_id, event_type, payload)
-		VALUES ($1, $2, $3)`,
-		event.EventID, "orders.created", payload,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("insert outbox: %w", err)
+	if idem != nil {
+		if err := idempotency.Store(ctx, tx, idempotencyTable, *idem, order); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -91,19 +176,59 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID, amount int64) (*
 	return order, nil
 }
 
-func (s *Service) List(ctx context.Context, userID uuid.UUID) ([]Order, error) {
-	rows, err := s.pool.Query(ctx, `
+func (s *Service) List(ctx context.Context, userID uuid.UUID, filter ListFilter) (*Page, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	conds := []string{"user_id = $1"}
+	args := []any{userID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Status != "" {
+		conds = append(conds, "status = "+arg(filter.Status))
+	}
+	if filter.CreatedAfter != nil {
+		conds = append(conds, "created_at >= "+arg(*filter.CreatedAfter))
+	}
+	if filter.CreatedBefore != nil {
+		conds = append(conds, "created_at < "+arg(*filter.CreatedBefore))
+	}
+	if filter.MinAmount != nil {
+		conds = append(conds, "amount >= "+arg(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		conds = append(conds, "amount <= "+arg(*filter.MaxAmount))
+	}
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(c.CreatedAt), arg(c.ID)))
+	}
+
+	query := fmt.Sprintf(`
 		SELECT id, user_id, amount, status, created_at, updated_at
 		FROM orders
-		WHERE user_id = $1
-		ORDER BY created_at DESC`, userID,
-	)
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT %s`, strings.Join(conds, " AND "), arg(limit+1))
+
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query orders: %w", err)
 	}
 	defer rows.Close()
 
-	var result []Order
+	result := []Order{}
 	for rows.Next() {
 		var o Order
 		if err := rows.Scan(&o.ID, &o.UserID, &o.Amount, &o.Status, &o.CreatedAt, &o.UpdatedAt); err != nil {
@@ -111,8 +236,28 @@ func (s *Service) List(ctx context.Context, userID uuid.UUID) ([]Order, error) {
 		}
 		result = append(result, o)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return result, rows.Err()
+	page := &Page{Orders: result}
+	if len(result) > limit {
+		page.Orders = result[:limit]
+		page.NextCursor = encodeCursor(page.Orders[limit-1])
+	}
+
+	ids := make([]string, len(page.Orders))
+	for i, o := range page.Orders {
+		ids[i] = o.ID
+	}
+	items, err := s.loadItems(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Orders {
+		page.Orders[i].Items = items[page.Orders[i].ID]
+	}
+	return page, nil
 }
 
 func (s *Service) Get(ctx context.Context, userID uuid.UUID, orderID uuid.UUID) (*Order, error) {
@@ -129,10 +274,21 @@ func (s *Service) Get(ctx context.Context, userID uuid.UUID, orderID uuid.UUID)
 		}
 		return nil, fmt.Errorf("get order: %w", err)
 	}
+
+	items, err := s.loadItems(ctx, []string{o.ID})
+	if err != nil {
+		return nil, err
+	}
+	o.Items = items[o.ID]
 	return &o, nil
 }
 
-func (s *Service) ApplyPaymentResult(ctx context.Context, evt contracts.PaymentProcessedEvent) error {
+func (s *Service) ApplyPaymentResult(ctx context.Context, evt contracts.PaymentProcessedEvent) (err error) {
+	ctx, span := tracer.Start(ctx, "order.ApplyPaymentResult", trace.WithAttributes(attribute.String("order.id", evt.OrderID), attribute.String("payment.status", string(evt.Status))))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
 	eventID, err := uuid.Parse(evt.EventID)
 	if err != nil {
 		return fmt.Errorf("invalid event id: %w", err)
@@ -152,7 +308,7 @@ func (s *Service) ApplyPaymentResult(ctx context.Context, evt contracts.PaymentP
 		INSERT INTO order_inbox (event_id, event_type)
 		VALUES ($1, $2)
 		ON CONFLICT (event_id) DO NOTHING`,
-		eventID, "payments.processed")
+		eventID, contracts.EventPaymentProcessed)
 	if err != nil {
 		return fmt.Errorf("insert inbox: %w", err)
 	}
@@ -169,22 +325,339 @@ func (s *Service) ApplyPaymentResult(ctx context.Context, evt contracts.PaymentP
 		status = StatusFailed
 	}
 
-	tag, err = tx.Exec(ctx, `
+	if err := s.transition(ctx, tx, orderID, status, evt.Reason); err != nil {
+		return commitRejected(ctx, tx, err)
+	}
+
+	var createdAt time.Time
+	if status == StatusPaid {
+		if err := tx.QueryRow(ctx, `SELECT created_at FROM orders WHERE id = $1`, orderID).Scan(&createdAt); err != nil {
+			return fmt.Errorf("select order created_at: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.broadcast(evt.OrderID, status)
+	if status == StatusPaid {
+		paidLatency.Observe(time.Since(createdAt).Seconds())
+	}
+	return nil
+}
+
+func (s *Service) Cancel(ctx context.Context, userID uuid.UUID, orderID uuid.UUID) (*Order, error) {
+	return s.changeStatus(ctx, userID, orderID, StatusCancelled, "cancelled_by_user", func(o *Order) (string, string, any) {
+		evt := contracts.OrderCancelledEvent{
+			EventID:     uuid.New().String(),
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Amount:      o.Amount,
+			CancelledAt: o.UpdatedAt,
+		}
+		return evt.EventID, contracts.EventOrderCancelled, evt
+	})
+}
+
+func (s *Service) Complete(ctx context.Context, userID uuid.UUID, orderID uuid.UUID) (*Order, error) {
+	return s.changeStatus(ctx, userID, orderID, StatusCompleted, "", func(o *Order) (string, string, any) {
+		evt := contracts.OrderCompletedEvent{
+			EventID:     uuid.New().String(),
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Amount:      o.Amount,
+			CompletedAt: o.UpdatedAt,
+		}
+		return evt.EventID, contracts.EventOrderCompleted, evt
+	})
+}
+
+func (s *Service) changeStatus(ctx context.Context, userID uuid.UUID, orderID uuid.UUID, to Status, reason string, event func(o *Order) (string, string, any)) (*Order, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var o Order
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, amount, status, created_at, updated_at
+		FROM orders
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE`,
+		orderID, userID,
+	).Scan(&o.ID, &o.UserID, &o.Amount, &o.Status, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("get order: %w", err)
+	}
+
+	if err := s.transition(ctx, tx, orderID, to, reason); err != nil {
+		return nil, err
+	}
+
+	o.Status = to
+	o.UpdatedAt = time.Now().UTC()
+
+	eventID, eventType, evt := event(&o)
+	env, err := contracts.NewEnvelope(ctx, contracts.ProducerOrders, eventType, eventID, evt)
+	if err != nil {
+		return nil, err
+	}
+	if err := messaging.InsertOutbox(ctx, tx, outboxTable, env); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.broadcast(o.ID, o.Status)
+	return &o, nil
+}
+
+func (s *Service) ApplyRefund(ctx context.Context, evt contracts.PaymentRefundedEvent) error {
+	eventID, err := uuid.Parse(evt.EventID)
+	if err != nil {
+		return fmt.Errorf("invalid event id: %w", err)
+	}
+	orderID, err := uuid.Parse(evt.OrderID)
+	if err != nil {
+		return fmt.Errorf("invalid order id: %w", err)
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO order_inbox (event_id, event_type)
+		VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING`,
+		eventID, contracts.EventPaymentRefunded)
+	if err != nil {
+		return fmt.Errorf("insert inbox: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if err := s.transition(ctx, tx, orderID, StatusRefunded, ""); err != nil {
+		return commitRejected(ctx, tx, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.broadcast(evt.OrderID, StatusRefunded)
+	return nil
+}
+
+func (s *Service) ApplyPaymentExpired(ctx context.Context, evt contracts.PaymentExpiredEvent) error {
+	eventID, err := uuid.Parse(evt.EventID)
+	if err != nil {
+		return fmt.Errorf("invalid event id: %w", err)
+	}
+	orderID, err := uuid.Parse(evt.OrderID)
+	if err != nil {
+		return fmt.Errorf("invalid order id: %w", err)
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO order_inbox (event_id, event_type)
+		VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING`,
+		eventID, contracts.EventPaymentExpired)
+	if err != nil {
+		return fmt.Errorf("insert inbox: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if err := s.transition(ctx, tx, orderID, StatusCancelled, "authorization_expired"); err != nil {
+		return commitRejected(ctx, tx, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	s.broadcast(evt.OrderID, StatusCancelled)
+	return nil
+}
+
+// broadcast notifies WebSocket subscribers. It must only be called after
+// the status change is committed.
+func (s *Service) broadcast(orderID string, status Status) {
+	if s.broadcaster != nil {
+		s.broadcaster.BroadcastOrderUpdate(orderID, string(status))
+	}
+}
+
+func (s *Service) History(ctx context.Context, userID uuid.UUID, orderID uuid.UUID) ([]StatusChange, error) {
+	if _, err := s.Get(ctx, userID, orderID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT COALESCE(from_status, ''), to_status, COALESCE(reason, ''), created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY id`, orderID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query history: %w", err)
+	}
+	defer rows.Close()
+
+	result := []StatusChange{}
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.From, &c.To, &c.Reason, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *Service) loadItems(ctx context.Context, orderIDs []string) (map[string][]Item, error) {
+	result := make(map[string][]Item, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT order_id, sku, name, quantity, unit_price
+		FROM order_items
+		WHERE order_id = ANY($1::uuid[])
+		ORDER BY order_id, sku`, orderIDs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query order items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID string
+			item    Item
+		)
+		if err := rows.Scan(&orderID, &item.SKU, &item.Name, &item.Quantity, &item.UnitPrice); err != nil {
+			return nil, err
+		}
+		result[orderID] = append(result[orderID], item)
+	}
+	return result, rows.Err()
+}
+
+func mergeItems(items []ItemInput) (map[string]int, []string, error) {
+	if len(items) == 0 {
+		return nil, nil, fmt.Errorf("%w: order must contain at least one item", ErrInvalidItems)
+	}
+
+	quantities := make(map[string]int, len(items))
+	skus := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.SKU == "" {
+			return nil, nil, fmt.Errorf("%w: item sku is required", ErrInvalidItems)
+		}
+		if item.Quantity <= 0 {
+			return nil, nil, fmt.Errorf("%w: must be positive", ErrInvalidQuantity)
+		}
+		if _, seen := quantities[item.SKU]; !seen {
+			skus = append(skus, item.SKU)
+		}
+		// Both sides are capped, so the sum cannot overflow.
+		if item.Quantity > MaxItemQuantity || quantities[item.SKU]+item.Quantity > MaxItemQuantity {
+			return nil, nil, fmt.Errorf("%w: at most %d per product", ErrInvalidQuantity, MaxItemQuantity)
+		}
+		quantities[item.SKU] += item.Quantity
+	}
+	return quantities, skus, nil
+}
+
+// addLine adds the line total to amount, failing instead of wrapping around
+// on overflow.
+func addLine(amount int64, line Item) (int64, error) {
+	if line.UnitPrice <= 0 || line.Quantity <= 0 {
+		return 0, fmt.Errorf("invalid line %s", line.SKU)
+	}
+	if line.UnitPrice > math.MaxInt64/int64(line.Quantity) {
+		return 0, ErrAmountOverflow
+	}
+	total := line.UnitPrice * int64(line.Quantity)
+	if amount > math.MaxInt64-total {
+		return 0, ErrAmountOverflow
+	}
+	return amount + total, nil
+}
+
+func (s *Service) transition(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, to Status, reason string) error {
+	var from Status
+	err := tx.QueryRow(ctx, `
+		SELECT status
+		FROM orders
+		WHERE id = $1
+		FOR UPDATE`,
+		orderID,
+	).Scan(&from)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return fmt.Errorf("select order status: %w", err)
+	}
+
+	if err := validateTransition(from, to); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
 		UPDATE orders
 		SET status = $2, updated_at = NOW()
 		WHERE id = $1`,
-		orderID, status,
+		orderID, to,
 	)
 	if err != nil {
 		return fmt.Errorf("update order status: %w", err)
 	}
-	if tag.RowsAffected() == 0 {
-		return ErrOrderNotFound
-	}
 
-	if s.broadcaster != nil {
-		s.broadcaster.BroadcastOrderUpdate(evt.OrderID, string(status))
+	return recordTransition(ctx, tx, orderID, from, to, reason)
+}
+
+func recordTransition(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, from, to Status, reason string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, reason)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''))`,
+		orderID, string(from), string(to), reason,
+	)
+	if err != nil {
+		return fmt.Errorf("insert status history: %w", err)
 	}
+	return nil
+}
 
-	return tx.Commit(ctx)
+// commitRejected keeps the inbox record of an event whose transition was
+// rejected, so that redeliveries of a stale event are not retried forever.
+func commitRejected(ctx context.Context, tx pgx.Tx, err error) error {
+	if errors.Is(err, ErrInvalidTransition) {
+		if cerr := tx.Commit(ctx); cerr != nil {
+			return cerr
+		}
+	}
+	return err
 }
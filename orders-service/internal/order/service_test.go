@@ -0,0 +1,356 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"gozon/pkg/contracts"
+	"gozon/pkg/pgtest"
+
+	"github.com/google/uuid"
+)
+
+func TestMergeItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    []ItemInput
+		want     map[string]int
+		wantSKUs []string
+		wantErr  error
+	}{
+		{
+			name:     "merges duplicate skus in first-seen order",
+			items:    []ItemInput{{SKU: "b", Quantity: 1}, {SKU: "a", Quantity: 2}, {SKU: "b", Quantity: 3}},
+			want:     map[string]int{"a": 2, "b": 4},
+			wantSKUs: []string{"b", "a"},
+		},
+		{
+			name:     "quantity at the cap",
+			items:    []ItemInput{{SKU: "a", Quantity: MaxItemQuantity}},
+			want:     map[string]int{"a": MaxItemQuantity},
+			wantSKUs: []string{"a"},
+		},
+		{name: "no items", wantErr: ErrInvalidItems},
+		{name: "empty sku", items: []ItemInput{{Quantity: 1}}, wantErr: ErrInvalidItems},
+		{name: "zero quantity", items: []ItemInput{{SKU: "a"}}, wantErr: ErrInvalidQuantity},
+		{name: "negative quantity", items: []ItemInput{{SKU: "a", Quantity: -1}}, wantErr: ErrInvalidQuantity},
+		{name: "quantity over the cap", items: []ItemInput{{SKU: "a", Quantity: MaxItemQuantity + 1}}, wantErr: ErrInvalidQuantity},
+		{name: "huge quantity", items: []ItemInput{{SKU: "a", Quantity: math.MaxInt}}, wantErr: ErrInvalidQuantity},
+		{
+			name:    "merged quantity over the cap",
+			items:   []ItemInput{{SKU: "a", Quantity: MaxItemQuantity}, {SKU: "a", Quantity: 1}},
+			wantErr: ErrInvalidQuantity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quantities, skus, err := mergeItems(tt.items)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatal("mergeItems() succeeded, want error")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("mergeItems() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeItems() error = %v", err)
+			}
+			if len(quantities) != len(tt.want) {
+				t.Fatalf("quantities = %v, want %v", quantities, tt.want)
+			}
+			for sku, q := range tt.want {
+				if quantities[sku] != q {
+					t.Errorf("quantities[%s] = %d, want %d", sku, quantities[sku], q)
+				}
+			}
+			for i := range tt.wantSKUs {
+				if skus[i] != tt.wantSKUs[i] {
+					t.Errorf("skus = %v, want %v", skus, tt.wantSKUs)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestAddLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		line    Item
+		want    int64
+		wantErr error
+	}{
+		{name: "simple", amount: 100, line: Item{UnitPrice: 250, Quantity: 4}, want: 1100},
+		{name: "exactly max", line: Item{UnitPrice: math.MaxInt64, Quantity: 1}, want: math.MaxInt64},
+		{name: "multiplication overflows", line: Item{UnitPrice: math.MaxInt64/2 + 1, Quantity: 2}, wantErr: ErrAmountOverflow},
+		{name: "product wraps to small", line: Item{UnitPrice: 1 << 62, Quantity: 4}, wantErr: ErrAmountOverflow},
+		{name: "sum overflows", amount: math.MaxInt64 - 10, line: Item{UnitPrice: 11, Quantity: 1}, wantErr: ErrAmountOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := addLine(tt.amount, tt.line)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("addLine() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("addLine() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// fakeOrders scripts the statements the service runs against an in-memory
+// orders table. Changes are applied as they run; tests check db.Commits and
+// db.Rollbacks to see whether they would have stuck.
+type fakeOrders struct {
+	db *pgtest.DB
+
+	mu        sync.Mutex
+	user      uuid.UUID
+	status    map[uuid.UUID]Status
+	inbox     map[uuid.UUID]bool
+	outbox    []string
+	broadcast []Status
+	// committedAtBroadcast is the commit count seen by the last broadcast.
+	committedAtBroadcast int
+}
+
+func newFakeOrders(t *testing.T) (*fakeOrders, *Service) {
+	t.Helper()
+	f := &fakeOrders{
+		db:     pgtest.New(),
+		user:   uuid.New(),
+		status: map[uuid.UUID]Status{},
+		inbox:  map[uuid.UUID]bool{},
+	}
+	now := time.Now().UTC()
+	f.db.
+		On("INSERT INTO order_inbox", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			id := args[0].(uuid.UUID)
+			if f.inbox[id] {
+				return pgtest.Affected(0)
+			}
+			f.inbox[id] = true
+			return pgtest.Affected(1)
+		}).
+		On("FROM orders WHERE id = $1 AND user_id = $2 FOR UPDATE", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			id := args[0].(uuid.UUID)
+			status, ok := f.status[id]
+			if !ok || args[1].(uuid.UUID) != f.user {
+				return pgtest.Rows()
+			}
+			return pgtest.Row(id.String(), f.user.String(), int64(500), string(status), now, now)
+		}).
+		On("SELECT status FROM orders WHERE id = $1 FOR UPDATE", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			status, ok := f.status[args[0].(uuid.UUID)]
+			if !ok {
+				return pgtest.Rows()
+			}
+			return pgtest.Row(string(status))
+		}).
+		On("SELECT created_at FROM orders", func([]any) pgtest.Result {
+			return pgtest.Row(now)
+		}).
+		On("UPDATE orders SET status", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.status[args[0].(uuid.UUID)] = args[1].(Status)
+			return pgtest.Affected(1)
+		}).
+		On("INSERT INTO order_status_history", func([]any) pgtest.Result {
+			return pgtest.Affected(1)
+		}).
+		On("INSERT INTO order_outbox", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			eventType, _ := contracts.ParseType(args[1].(string))
+			f.outbox = append(f.outbox, eventType)
+			return pgtest.Affected(1)
+		})
+	return f, &Service{pool: f.db, broadcaster: f}
+}
+
+func (f *fakeOrders) BroadcastOrderUpdate(orderID string, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.broadcast = append(f.broadcast, Status(status))
+	f.committedAtBroadcast = f.db.Commits()
+}
+
+func (f *fakeOrders) add(status Status) uuid.UUID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := uuid.New()
+	f.status[id] = status
+	return id
+}
+
+func TestChangeStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      Status
+		call      func(*Service) func(context.Context, uuid.UUID, uuid.UUID) (*Order, error)
+		want      Status
+		wantEvent string
+		wantErr   error
+	}{
+		{name: "cancel pending", from: StatusPending, call: cancel, want: StatusCancelled, wantEvent: contracts.EventOrderCancelled},
+		{name: "cancel paid", from: StatusPaid, call: cancel, want: StatusCancelled, wantEvent: contracts.EventOrderCancelled},
+		{name: "complete paid", from: StatusPaid, call: complete, want: StatusCompleted, wantEvent: contracts.EventOrderCompleted},
+		{name: "complete pending", from: StatusPending, call: complete, wantErr: ErrInvalidTransition},
+		{name: "cancel refunded", from: StatusRefunded, call: cancel, wantErr: ErrInvalidTransition},
+		{name: "unknown order", call: cancel, wantErr: ErrOrderNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, s := newFakeOrders(t)
+			id := uuid.New()
+			if tt.from != "" {
+				id = f.add(tt.from)
+			}
+
+			got, err := tt.call(s)(context.Background(), f.user, id)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if f.db.Commits() != 0 || len(f.outbox) != 0 || len(f.broadcast) != 0 {
+					t.Errorf("rejected change committed %d times, outbox %v, broadcast %v", f.db.Commits(), f.outbox, f.broadcast)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.want || f.status[id] != tt.want {
+				t.Errorf("status = %s (stored %s), want %s", got.Status, f.status[id], tt.want)
+			}
+			if len(f.outbox) != 1 || f.outbox[0] != tt.wantEvent {
+				t.Errorf("outbox = %v, want [%s]", f.outbox, tt.wantEvent)
+			}
+			if f.db.Commits() != 1 {
+				t.Errorf("commits = %d, want 1", f.db.Commits())
+			}
+			if len(f.broadcast) != 1 || f.broadcast[0] != tt.want || f.committedAtBroadcast != 1 {
+				t.Errorf("broadcast %v after %d commits, want [%s] after the commit", f.broadcast, f.committedAtBroadcast, tt.want)
+			}
+		})
+	}
+}
+
+func cancel(s *Service) func(context.Context, uuid.UUID, uuid.UUID) (*Order, error) {
+	return s.Cancel
+}
+
+func complete(s *Service) func(context.Context, uuid.UUID, uuid.UUID) (*Order, error) {
+	return s.Complete
+}
+
+func TestChangeStatusOtherUser(t *testing.T) {
+	f, s := newFakeOrders(t)
+	id := f.add(StatusPending)
+	if _, err := s.Cancel(context.Background(), uuid.New(), id); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("Cancel() by another user error = %v, want ErrOrderNotFound", err)
+	}
+	if f.status[id] != StatusPending {
+		t.Errorf("status = %s, want it unchanged", f.status[id])
+	}
+}
+
+func TestApplyRefundInbox(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("applied once", func(t *testing.T) {
+		f, s := newFakeOrders(t)
+		id := f.add(StatusCancelled)
+		evt := contracts.PaymentRefundedEvent{EventID: uuid.NewString(), OrderID: id.String()}
+
+		if err := s.ApplyRefund(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+		if f.status[id] != StatusRefunded || f.db.Commits() != 1 || len(f.broadcast) != 1 {
+			t.Fatalf("status %s, commits %d, broadcast %v", f.status[id], f.db.Commits(), f.broadcast)
+		}
+
+		// A redelivery is recognised by the inbox and changes nothing.
+		if err := s.ApplyRefund(ctx, evt); err != nil {
+			t.Fatalf("duplicate ApplyRefund() error = %v", err)
+		}
+		if n := len(f.db.Calls("UPDATE orders")); n != 1 {
+			t.Errorf("duplicate updated the order again (%d updates)", n)
+		}
+		if f.db.Commits() != 1 || len(f.broadcast) != 1 {
+			t.Errorf("duplicate committed (%d) or broadcast (%v)", f.db.Commits(), f.broadcast)
+		}
+	})
+
+	t.Run("rejected transition", func(t *testing.T) {
+		f, s := newFakeOrders(t)
+		id := f.add(StatusPending)
+		evt := contracts.PaymentRefundedEvent{EventID: uuid.NewString(), OrderID: id.String()}
+
+		err := s.ApplyRefund(ctx, evt)
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("ApplyRefund() error = %v, want ErrInvalidTransition", err)
+		}
+		// The inbox row is committed so that the stale event is not
+		// retried forever, but the order is left alone.
+		if f.db.Commits() != 1 || !f.inbox[uuid.MustParse(evt.EventID)] {
+			t.Errorf("commits = %d, inbox = %v, want the inbox row committed", f.db.Commits(), f.inbox)
+		}
+		if f.status[id] != StatusPending || len(f.broadcast) != 0 {
+			t.Errorf("status %s, broadcast %v, want the order unchanged", f.status[id], f.broadcast)
+		}
+
+		if err := s.ApplyRefund(ctx, evt); err != nil {
+			t.Errorf("redelivered ApplyRefund() error = %v, want nil", err)
+		}
+	})
+}
+
+func TestApplyPaymentResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    Status
+		result  contracts.PaymentStatus
+		want    Status
+		wantErr error
+	}{
+		{name: "paid", from: StatusPending, result: contracts.PaymentSucceeded, want: StatusPaid},
+		{name: "failed", from: StatusPending, result: contracts.PaymentFailed, want: StatusFailed},
+		{name: "late result for cancelled order", from: StatusCancelled, result: contracts.PaymentSucceeded, want: StatusCancelled, wantErr: ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, s := newFakeOrders(t)
+			id := f.add(tt.from)
+			err := s.ApplyPaymentResult(context.Background(), contracts.PaymentProcessedEvent{
+				EventID: uuid.NewString(),
+				OrderID: id.String(),
+				Status:  tt.result,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if f.status[id] != tt.want || f.db.Commits() != 1 {
+				t.Errorf("status %s after %d commits, want %s after 1", f.status[id], f.db.Commits(), tt.want)
+			}
+		})
+	}
+}
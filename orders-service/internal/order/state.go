@@ -0,0 +1,44 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid status transition %s -> %s", e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+var transitions = map[Status][]Status{
+	StatusPending:   {StatusPaid, StatusFailed, StatusCancelled},
+	StatusPaid:      {StatusCompleted, StatusCancelled},
+	StatusCompleted: {StatusCancelled},
+	StatusCancelled: {StatusRefunded},
+}
+
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func validateTransition(from, to Status) error {
+	if !from.CanTransitionTo(to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package order
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateTransition(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		ok       bool
+	}{
+		{StatusPending, StatusPaid, true},
+		{StatusPending, StatusFailed, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusPending, StatusCompleted, false},
+		{StatusPending, StatusRefunded, false},
+		{StatusPaid, StatusCompleted, true},
+		{StatusPaid, StatusCancelled, true},
+		{StatusPaid, StatusFailed, false},
+		{StatusPaid, StatusPending, false},
+		{StatusCompleted, StatusCancelled, true},
+		{StatusCompleted, StatusPaid, false},
+		{StatusCancelled, StatusRefunded, true},
+		{StatusCancelled, StatusPaid, false},
+		{StatusCancelled, StatusCancelled, false},
+		{StatusFailed, StatusPaid, false},
+		{StatusFailed, StatusCancelled, false},
+		{StatusRefunded, StatusCancelled, false},
+		{Status("unknown"), StatusPaid, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			err := validateTransition(tt.from, tt.to)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("validateTransition() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidTransition) {
+				t.Fatalf("validateTransition() error = %v, want ErrInvalidTransition", err)
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.From != tt.from || te.To != tt.to {
+				t.Errorf("validateTransition() error = %#v, want TransitionError{%s, %s}", err, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestTerminalStatuses(t *testing.T) {
+	all := []Status{StatusPending, StatusPaid, StatusFailed, StatusCancelled, StatusRefunded, StatusCompleted}
+	for _, from := range []Status{StatusFailed, StatusRefunded} {
+		for _, to := range all {
+			if from.CanTransitionTo(to) {
+				t.Errorf("%s is terminal but can move to %s", from, to)
+			}
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package order
+
+import "go.opentelemetry.io/otel"
+
+var tracer = otel.Tracer("gozon/orders-service/order")
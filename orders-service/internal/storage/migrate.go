@@ -3,8 +3,9 @@ package storage
 import (
 	"context"
 	"embed"
-	"fmt"
-	"sort"
+	"log/slog"
+
+	"gozon/pkg/migrate"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -12,30 +13,19 @@ import (
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
-func RunMigrations(ctx context.Context, pool *pgxpool.Pool) error {
-	entries, err := migrationsFS.ReadDir("migrations")
+func NewMigrator(pool *pgxpool.Pool, logger *slog.Logger) (*migrate.Migrator, error) {
+	migrations, err := migrate.Load(migrationsFS, "migrations")
 	if err != nil {
-		return fmt.Errorf("read migrations: %w", err)
+		return nil, err
 	}
+	return migrate.New(pool, migrations, logger), nil
+}
 
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Name() < entries[j].Name()
-	})
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		body, err := migrationsFS.ReadFile("migrations/" + entry.Name())
-		if err != nil {
-			return fmt.Errorf("read migration %s: %w", entry.Name(), err)
-		}
-
-		if _, err := pool.Exec(ctx, string(body)); err != nil {
-			return fmt.Errorf("exec migration %s: %w", entry.Name(), err)
-		}
+func RunMigrations(ctx context.Context, pool *pgxpool.Pool) error {
+	migrator, err := NewMigrator(pool, nil)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	_, err = migrator.Up(ctx)
+	return err
 }
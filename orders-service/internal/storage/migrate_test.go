@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"testing"
+
+	"gozon/pkg/migrate"
+)
+
+func TestMigrationsLoad(t *testing.T) {
+	migrations, err := migrate.Load(migrationsFS, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %03d_%s: want version %d, versions must have no gaps", m.Version, m.Name, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %03d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_inbox;
+DROP TABLE IF EXISTS order_outbox;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS order_dead_letter_audit (
+    id BIGSERIAL PRIMARY KEY,
+    queue TEXT NOT NULL,
+    source TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    routing_key TEXT NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    result TEXT NOT NULL,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_dead_letter_audit_message_idx ON order_dead_letter_audit (message_id);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS order_outbox_notify ON order_outbox;
+DROP FUNCTION IF EXISTS notify_outbox();
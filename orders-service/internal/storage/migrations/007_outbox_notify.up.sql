@@ -0,0 +1,13 @@
+CREATE OR REPLACE FUNCTION notify_outbox() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify(TG_TABLE_NAME, '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_outbox_notify ON order_outbox;
+
+CREATE TRIGGER order_outbox_notify
+    AFTER INSERT ON order_outbox
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_outbox();
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS order_inbox_received_idx;
+
+DROP TABLE IF EXISTS order_outbox_archive;
+
+DROP INDEX IF EXISTS order_outbox_sent_idx;
+
+ALTER TABLE order_outbox DROP COLUMN IF EXISTS last_error;
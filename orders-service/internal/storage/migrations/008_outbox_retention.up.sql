@@ -0,0 +1,16 @@
+ALTER TABLE order_outbox ADD COLUMN IF NOT EXISTS last_error TEXT;
+
+CREATE INDEX IF NOT EXISTS order_outbox_sent_idx ON order_outbox (updated_at) WHERE status = 'sent';
+
+CREATE TABLE IF NOT EXISTS order_outbox_archive (
+    id BIGINT PRIMARY KEY,
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    sent_at TIMESTAMPTZ NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_inbox_received_idx ON order_inbox (received_at);
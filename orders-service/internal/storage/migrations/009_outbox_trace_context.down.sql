@@ -0,0 +1 @@
+ALTER TABLE order_outbox DROP COLUMN trace_context;
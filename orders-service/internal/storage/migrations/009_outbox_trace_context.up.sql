@@ -0,0 +1 @@
+ALTER TABLE order_outbox ADD COLUMN trace_context JSONB;
@@ -13,6 +13,21 @@ type Store struct {
 }
 
 func New(ctx context.Context, url string) (*Store, error) {
+	pool, err := Connect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RunMigrations(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &Store{pool: pool}, nil
+}
+
+// Connect opens a pool without touching the schema.
+func Connect(ctx context.Context, url string) (*pgxpool.Pool, error) {
 	cfg, err := pgxpool.ParseConfig(url)
 	if err != nil {
 		return nil, fmt.Errorf("parse database url: %w", err)
@@ -24,13 +39,7 @@ func New(ctx context.Context, url string) (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("connect database: %w", err)
 	}
-
-	if err := RunMigrations(ctx, pool); err != nil {
-		pool.Close()
-		return nil, err
-	}
-
-	return &Store{pool: pool}, nil
+	return pool, nil
 }
 
 func (s *Store) Pool() *pgxpool.Pool {
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"gozon/orders-service/internal/order"
+	"gozon/pkg/auth"
 
 	"github.com/google/uuid"
 	gw "github.com/gorilla/websocket"
@@ -30,24 +31,19 @@ func NewHandler(hub *Hub, orderSvc *order.Service) *Handler {
 }
 
 func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	userID, err := auth.UserFrom(r.Context())
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	orderIDStr := r.PathValue("orderID")
-	orderID, err := uuid.Parse(orderIDStr)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		_ = conn.Close()
 		return
 	}
 
-	userHeader := r.Header.Get("X-User-ID")
-	if userHeader == "" {
-		_ = conn.Close()
-		return
-	}
-	userID, err := uuid.Parse(userHeader)
+	orderIDStr := r.PathValue("orderID")
+	orderID, err := uuid.Parse(orderIDStr)
 	if err != nil {
 		_ = conn.Close()
 		return
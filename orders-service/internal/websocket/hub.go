@@ -43,11 +43,13 @@ func (h *Hub) Run(ctx context.Context) {
 				h.clients[c.orderID] = set
 			}
 			h.clients[c.orderID][c] = true
+			connectedClients.Inc()
 		case c := <-h.unregister:
 			if set, ok := h.clients[c.orderID]; ok {
 				if _, exists := set[c]; exists {
 					delete(set, c)
 					close(c.send)
+					connectedClients.Dec()
 				}
 				if len(set) == 0 {
 					delete(h.clients, c.orderID)
@@ -62,6 +64,8 @@ func (h *Hub) Run(ctx context.Context) {
 					default:
 						delete(set, c)
 						close(c.send)
+						connectedClients.Dec()
+						droppedSlowClients.Inc()
 					}
 				}
 			}
@@ -71,6 +75,7 @@ func (h *Hub) Run(ctx context.Context) {
 					close(c.send)
 				}
 			}
+			connectedClients.Set(0)
 			return
 		}
 	}
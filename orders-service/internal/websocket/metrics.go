@@ -0,0 +1,18 @@
+package websocket
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	connectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_connected_clients",
+		Help: "WebSocket clients currently subscribed to order updates.",
+	})
+
+	droppedSlowClients = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_slow_clients_dropped_total",
+		Help: "Clients disconnected because their send buffer was full.",
+	})
+)
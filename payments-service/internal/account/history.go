@@ -0,0 +1,231 @@
+package account
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type Transaction struct {
+	ID           string    `json:"id"`
+	OrderID      *string   `json:"order_id,omitempty"`
+	Counterparty *string   `json:"counterparty_id,omitempty"`
+	Kind         Kind      `json:"kind"`
+	Amount       int64     `json:"amount"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type TransactionFilter struct {
+	Kind   Kind
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Cursor string
+}
+
+type TransactionPage struct {
+	Transactions []Transaction
+	NextCursor   string
+}
+
+type StatementLine struct {
+	Transaction
+	Balance int64 `json:"balance"`
+}
+
+type Statement struct {
+	From           *time.Time      `json:"from,omitempty"`
+	To             *time.Time      `json:"to,omitempty"`
+	OpeningBalance int64           `json:"opening_balance"`
+	Lines          []StatementLine `json:"lines"`
+	ClosingBalance int64           `json:"closing_balance"`
+}
+
+func (k Kind) Valid() bool {
+	_, ok := postings[k]
+	return ok
+}
+
+func (s *Service) Transactions(ctx context.Context, userID uuid.UUID, filter TransactionFilter) (*TransactionPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	if err := ensureAccount(ctx, s.pool, userID); err != nil {
+		return nil, err
+	}
+
+	conds := []string{"e.account = $1"}
+	args := []any{UserAccount(userID)}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Kind != "" {
+		conds = append(conds, "t.kind = "+arg(string(filter.Kind)))
+	}
+	if filter.From != nil {
+		conds = append(conds, "e.created_at >= "+arg(*filter.From))
+	}
+	if filter.To != nil {
+		conds = append(conds, "e.created_at < "+arg(*filter.To))
+	}
+	if filter.Cursor != "" {
+		before, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, "e.id < "+arg(before))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT e.id, t.id::text, t.order_id::text, t.counterparty_id::text, t.kind, e.amount, e.created_at
+		FROM ledger_entries e
+		JOIN account_transactions t ON t.id = e.transaction_id
+		WHERE %s
+		ORDER BY e.id DESC
+		LIMIT %s`, strings.Join(conds, " AND "), arg(limit+1))
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query transactions: %w", err)
+	}
+	defer rows.Close()
+
+	result := []Transaction{}
+	var ids []int64
+	for rows.Next() {
+		var (
+			entryID int64
+			t       Transaction
+		)
+		if err := rows.Scan(&entryID, &t.ID, &t.OrderID, &t.Counterparty, &t.Kind, &t.Amount, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+		ids = append(ids, entryID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &TransactionPage{Transactions: result}
+	if len(result) > limit {
+		page.Transactions = result[:limit]
+		page.NextCursor = encodeCursor(ids[limit-1])
+	}
+	return page, nil
+}
+
+func (s *Service) Statement(ctx context.Context, userID uuid.UUID, from, to *time.Time) (*Statement, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := ensureAccount(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	account := UserAccount(userID)
+	st := &Statement{From: from, To: to, Lines: []StatementLine{}}
+
+	if from != nil {
+		err = tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(amount), 0)
+			FROM ledger_entries
+			WHERE account = $1 AND created_at < $2`,
+			account, *from,
+		).Scan(&st.OpeningBalance)
+		if err != nil {
+			return nil, fmt.Errorf("select opening balance: %w", err)
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT t.id::text, t.order_id::text, t.counterparty_id::text, t.kind, e.amount, e.created_at
+		FROM ledger_entries e
+		JOIN account_transactions t ON t.id = e.transaction_id
+		WHERE e.account = $1
+		  AND ($2::timestamptz IS NULL OR e.created_at >= $2)
+		  AND ($3::timestamptz IS NULL OR e.created_at < $3)
+		ORDER BY e.id`,
+		account, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query statement: %w", err)
+	}
+	defer rows.Close()
+
+	running := st.OpeningBalance
+	for rows.Next() {
+		var line StatementLine
+		if err := rows.Scan(&line.ID, &line.OrderID, &line.Counterparty, &line.Kind, &line.Amount, &line.CreatedAt); err != nil {
+			return nil, err
+		}
+		running += line.Amount
+		line.Balance = running
+		st.Lines = append(st.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	st.ClosingBalance = running
+
+	return st, nil
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func ensureAccount(ctx context.Context, q querier, userID uuid.UUID) error {
+	var exists bool
+	err := q.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM accounts WHERE user_id = $1)`,
+		userID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("select account: %w", err)
+	}
+	if !exists {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+func encodeCursor(entryID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(entryID, 10)))
+}
+
+func decodeCursor(value string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
@@ -0,0 +1,101 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type Kind string
+
+const (
+	KindDeposit     Kind = "deposit"
+	KindDebit       Kind = "debit"
+	KindRefund      Kind = "refund"
+	KindWithdrawal  Kind = "withdrawal"
+	KindTransferOut Kind = "transfer_out"
+	KindTransferIn  Kind = "transfer_in"
+)
+
+const (
+	SystemCash      = "system:cash"
+	SystemRevenue   = "system:revenue"
+	SystemTransfers = "system:transfers"
+)
+
+type posting struct {
+	sign    int64
+	counter string
+}
+
+var postings = map[Kind]posting{
+	KindDeposit:     {sign: 1, counter: SystemCash},
+	KindDebit:       {sign: -1, counter: SystemRevenue},
+	KindRefund:      {sign: 1, counter: SystemRevenue},
+	KindWithdrawal:  {sign: -1, counter: SystemCash},
+	KindTransferOut: {sign: -1, counter: SystemTransfers},
+	KindTransferIn:  {sign: 1, counter: SystemTransfers},
+}
+
+type Movement struct {
+	UserID       uuid.UUID
+	OrderID      *uuid.UUID
+	Counterparty *uuid.UUID
+	Kind         Kind
+	Amount       int64
+}
+
+func UserAccount(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+// Post applies a movement to the user's balance, writes the matching
+// account_transactions row and posts two ledger entries that sum to zero.
+// It returns the new ledger balance of the account.
+func Post(ctx context.Context, tx pgx.Tx, m Movement) (int64, error) {
+	p, ok := postings[m.Kind]
+	if !ok {
+		return 0, fmt.Errorf("unknown movement kind %q", m.Kind)
+	}
+	if m.Amount <= 0 {
+		return 0, fmt.Errorf("amount must be positive")
+	}
+	delta := p.sign * m.Amount
+
+	var balance int64
+	err := tx.QueryRow(ctx, `
+		UPDATE accounts
+		SET balance = balance + $2, updated_at = NOW()
+		WHERE user_id = $1
+		RETURNING balance`, m.UserID, delta).Scan(&balance)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrAccountNotFound
+		}
+		return 0, fmt.Errorf("update balance: %w", err)
+	}
+
+	txID := uuid.New()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO account_transactions (id, user_id, order_id, counterparty_id, amount, kind)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		txID, m.UserID, m.OrderID, m.Counterparty, m.Amount, string(m.Kind),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("insert transaction: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO ledger_entries (transaction_id, account, amount)
+		VALUES ($1, $2, $3), ($1, $4, $5)`,
+		txID, UserAccount(m.UserID), delta, p.counter, -delta,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("insert ledger entries: %w", err)
+	}
+
+	return balance, nil
+}
@@ -0,0 +1,62 @@
+package account
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPostings(t *testing.T) {
+	tests := []struct {
+		kind    Kind
+		sign    int64
+		counter string
+	}{
+		{KindDeposit, 1, SystemCash},
+		{KindWithdrawal, -1, SystemCash},
+		{KindDebit, -1, SystemRevenue},
+		{KindRefund, 1, SystemRevenue},
+		{KindTransferOut, -1, SystemTransfers},
+		{KindTransferIn, 1, SystemTransfers},
+	}
+	if len(tests) != len(postings) {
+		t.Fatalf("postings has %d kinds, test covers %d", len(postings), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			p, ok := postings[tt.kind]
+			if !ok {
+				t.Fatalf("no posting for %s", tt.kind)
+			}
+			if p.sign != tt.sign || p.counter != tt.counter {
+				t.Errorf("posting = %+v, want sign %d against %s", p, tt.sign, tt.counter)
+			}
+			if !strings.HasPrefix(p.counter, "system:") {
+				t.Errorf("counter account %q is not a system account", p.counter)
+			}
+		})
+	}
+}
+
+func TestPostRejectsInvalidMovement(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Movement
+		want string
+	}{
+		{name: "unknown kind", m: Movement{UserID: uuid.New(), Kind: "bonus", Amount: 10}, want: "unknown movement kind"},
+		{name: "zero amount", m: Movement{UserID: uuid.New(), Kind: KindDeposit}, want: "amount must be positive"},
+		{name: "negative amount", m: Movement{UserID: uuid.New(), Kind: KindDebit, Amount: -5}, want: "amount must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Validation happens before the transaction is touched.
+			_, err := Post(context.Background(), nil, tt.m)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Post() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
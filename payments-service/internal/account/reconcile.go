@@ -0,0 +1,168 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Drift struct {
+	UserID  string `json:"user_id"`
+	Balance int64  `json:"balance"`
+	Ledger  int64  `json:"ledger"`
+	Diff    int64  `json:"diff"`
+}
+
+type ReconciliationReport struct {
+	CheckedAt              time.Time `json:"checked_at"`
+	Accounts               int       `json:"accounts"`
+	Drifts                 []Drift   `json:"drifts"`
+	UnbalancedTransactions []string  `json:"unbalanced_transactions"`
+	Repaired               int       `json:"repaired"`
+}
+
+type Reconciler struct {
+	pool   *pgxpool.Pool
+	logger *slog.Logger
+}
+
+func NewReconciler(pool *pgxpool.Pool, logger *slog.Logger) *Reconciler {
+	return &Reconciler{pool: pool, logger: logger}
+}
+
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration, repair bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := r.Reconcile(ctx, repair)
+		if err != nil {
+			r.logger.Error("reconciliation failed", "err", err)
+		} else {
+			for _, d := range report.Drifts {
+				r.logger.Warn("balance drift", "user_id", d.UserID, "balance", d.Balance, "ledger", d.Ledger, "diff", d.Diff)
+			}
+			for _, id := range report.UnbalancedTransactions {
+				r.logger.Error("unbalanced ledger transaction", "transaction_id", id)
+			}
+			if report.Repaired > 0 {
+				r.logger.Info("balances repaired from ledger", "count", report.Repaired)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reconciler) Reconcile(ctx context.Context, repair bool) (*ReconciliationReport, error) {
+	report := &ReconciliationReport{
+		CheckedAt:              time.Now().UTC(),
+		Drifts:                 []Drift{},
+		UnbalancedTransactions: []string{},
+	}
+
+	if err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM accounts`).Scan(&report.Accounts); err != nil {
+		return nil, fmt.Errorf("count accounts: %w", err)
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT a.user_id::text, a.balance, COALESCE(l.total, 0)
+		FROM accounts a
+		LEFT JOIN (
+			SELECT account, SUM(amount) AS total
+			FROM ledger_entries
+			GROUP BY account
+		) l ON l.account = 'user:' || a.user_id::text
+		WHERE a.balance <> COALESCE(l.total, 0)
+		ORDER BY a.user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("query drift: %w", err)
+	}
+	for rows.Next() {
+		var d Drift
+		if err := rows.Scan(&d.UserID, &d.Balance, &d.Ledger); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Diff = d.Balance - d.Ledger
+		report.Drifts = append(report.Drifts, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT transaction_id::text
+		FROM ledger_entries
+		GROUP BY transaction_id
+		HAVING SUM(amount) <> 0
+		ORDER BY transaction_id`)
+	if err != nil {
+		return nil, fmt.Errorf("query unbalanced transactions: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.UnbalancedTransactions = append(report.UnbalancedTransactions, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if repair {
+		for _, d := range report.Drifts {
+			fixed, err := r.repair(ctx, uuid.MustParse(d.UserID))
+			if err != nil {
+				return nil, err
+			}
+			if fixed {
+				report.Repaired++
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (r *Reconciler) repair(ctx context.Context, userID uuid.UUID) (bool, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM accounts WHERE user_id = $1 FOR UPDATE`, userID); err != nil {
+		return false, fmt.Errorf("lock account: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE accounts
+		SET balance = l.total, updated_at = NOW()
+		FROM (
+			SELECT COALESCE(SUM(amount), 0) AS total
+			FROM ledger_entries
+			WHERE account = $2
+		) l
+		WHERE user_id = $1 AND balance <> l.total`,
+		userID, UserAccount(userID),
+	)
+	if err != nil {
+		return false, fmt.Errorf("repair balance: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, tx.Commit(ctx)
+}
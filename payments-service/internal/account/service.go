@@ -2,10 +2,13 @@ package account
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
+	"gozon/pkg/idempotency"
+
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -17,6 +20,18 @@ var (
 	ErrAccountNotFound = errors.New("account not found")
 )
 
+const idempotencyTable = "payment_idempotency"
+
+type DepositResult struct {
+	Balance int64 `json:"balance"`
+}
+
+type Balance struct {
+	Balance   int64 `json:"balance"`
+	Available int64 `json:"available"`
+	Held      int64 `json:"held"`
+}
+
 type Service struct {
 	pool *pgxpool.Pool
 }
@@ -42,7 +57,7 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID) error {
 	return nil
 }
 
-func (s *Service) Deposit(ctx context.Context, userID uuid.UUID, amount int64) (int64, error) {
+func (s *Service) Deposit(ctx context.Context, userID uuid.UUID, amount int64, idem *idempotency.Key) (int64, error) {
 	if amount <= 0 {
 		return 0, fmt.Errorf("amount must be positive")
 	}
@@ -53,49 +68,56 @@ func (s *Service) Deposit(ctx context.Context, userID uuid.UUID, amount int64) (
 	}
 	defer tx.Rollback(ctx)
 
-	tag, err := tx.Exec(ctx, `
-		UPDATE accounts
-		SET balance = balance + $2, updated_at = NOW()
-		WHERE user_id = $1`, userID, amount)
-	if err != nil {
-		return 0, fmt.Errorf("update balance: %w", err)
-	}
-	if tag.RowsAffected() == 0 {
-		return 0, ErrAccountNotFound
+	if idem != nil {
+		stored, err := idempotency.Acquire(ctx, tx, idempotencyTable, *idem)
+		if err != nil {
+			return 0, err
+		}
+		if stored != nil {
+			var replay DepositResult
+			if err := json.Unmarshal(stored, &replay); err != nil {
+				return 0, fmt.Errorf("decode stored deposit: %w", err)
+			}
+			return replay.Balance, tx.Commit(ctx)
+		}
 	}
 
-	txID := uuid.New()
-	_, err = tx.Exec(ctx, `
-		INSERT INTO account_transactions (id, user_id, amount, kind)
-		VALUES ($1, $2, $3, $4)`,
-		txID, userID, amount, "deposit",
-	)
+	balance, err := Post(ctx, tx, Movement{UserID: userID, Kind: KindDeposit, Amount: amount})
 	if err != nil {
-		return 0, fmt.Errorf("insert transaction: %w", err)
+		return 0, err
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return 0, err
+	if idem != nil {
+		if err := idempotency.Store(ctx, tx, idempotencyTable, *idem, DepositResult{Balance: balance}); err != nil {
+			return 0, err
+		}
 	}
 
-	balance, err := s.GetBalance(ctx, userID)
-	if err != nil {
+	if err := tx.Commit(ctx); err != nil {
 		return 0, err
 	}
+
 	return balance, nil
 }
 
-func (s *Service) GetBalance(ctx context.Context, userID uuid.UUID) (int64, error) {
-	var balance int64
+func (s *Service) GetBalance(ctx context.Context, userID uuid.UUID) (Balance, error) {
+	var b Balance
 	err := s.pool.QueryRow(ctx, `
-		SELECT balance FROM accounts WHERE user_id = $1`,
+		SELECT a.balance, COALESCE((
+			SELECT SUM(h.amount)
+			FROM account_holds h
+			WHERE h.user_id = a.user_id AND h.status = 'active'
+		), 0)
+		FROM accounts a
+		WHERE a.user_id = $1`,
 		userID,
-	).Scan(&balance)
+	).Scan(&b.Balance, &b.Held)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, ErrAccountNotFound
+			return Balance{}, ErrAccountNotFound
 		}
-		return 0, fmt.Errorf("select balance: %w", err)
+		return Balance{}, fmt.Errorf("select balance: %w", err)
 	}
-	return balance, nil
+	b.Available = b.Balance - b.Held
+	return b, nil
 }
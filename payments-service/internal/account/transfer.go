@@ -0,0 +1,137 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const ReasonInsufficientFunds = "insufficient_funds"
+
+var (
+	ErrInsufficientFunds = errors.New(ReasonInsufficientFunds)
+	ErrSelfTransfer      = errors.New("cannot transfer to the same account")
+)
+
+type InsufficientFundsError struct {
+	Available int64
+	Requested int64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("%s: available %d, requested %d", ReasonInsufficientFunds, e.Available, e.Requested)
+}
+
+func (e *InsufficientFundsError) Unwrap() error {
+	return ErrInsufficientFunds
+}
+
+// LockAvailable locks the account row and returns the balance that is not
+// reserved by active holds. Holds past their TTL still count until the
+// expiry sweep releases them, since they can still be captured.
+func LockAvailable(ctx context.Context, tx pgx.Tx, userID uuid.UUID) (int64, error) {
+	var available int64
+	err := tx.QueryRow(ctx, `
+		SELECT a.balance - COALESCE((
+			SELECT SUM(h.amount)
+			FROM account_holds h
+			WHERE h.user_id = a.user_id AND h.status = 'active'
+		), 0)
+		FROM accounts a
+		WHERE a.user_id = $1
+		FOR UPDATE`,
+		userID,
+	).Scan(&available)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrAccountNotFound
+		}
+		return 0, fmt.Errorf("select balance: %w", err)
+	}
+	return available, nil
+}
+
+func (s *Service) Withdraw(ctx context.Context, userID uuid.UUID, amount int64) (int64, error) {
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount must be positive")
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	available, err := LockAvailable(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if available < amount {
+		return 0, &InsufficientFundsError{Available: available, Requested: amount}
+	}
+
+	balance, err := Post(ctx, tx, Movement{UserID: userID, Kind: KindWithdrawal, Amount: amount})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+func (s *Service) Transfer(ctx context.Context, from, to uuid.UUID, amount int64) (int64, error) {
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount must be positive")
+	}
+	if from == to {
+		return 0, ErrSelfTransfer
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	available := make(map[uuid.UUID]int64, 2)
+	for _, id := range lockOrder(from, to) {
+		v, err := LockAvailable(ctx, tx, id)
+		if err != nil {
+			return 0, err
+		}
+		available[id] = v
+	}
+
+	if available[from] < amount {
+		return 0, &InsufficientFundsError{Available: available[from], Requested: amount}
+	}
+
+	balance, err := Post(ctx, tx, Movement{UserID: from, Counterparty: &to, Kind: KindTransferOut, Amount: amount})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := Post(ctx, tx, Movement{UserID: to, Counterparty: &from, Kind: KindTransferIn, Amount: amount}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// lockOrder returns both accounts in user_id order. Locking in this order
+// keeps opposite transfers between the same pair of accounts from
+// deadlocking.
+func lockOrder(a, b uuid.UUID) []uuid.UUID {
+	if bytes.Compare(b[:], a[:]) < 0 {
+		return []uuid.UUID{b, a}
+	}
+	return []uuid.UUID{a, b}
+}
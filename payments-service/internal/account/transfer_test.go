@@ -0,0 +1,66 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestLockOrder(t *testing.T) {
+	low := uuid.MustParse("00000000-0000-0000-0000-0000000000aa")
+	high := uuid.MustParse("ff000000-0000-0000-0000-000000000001")
+
+	tests := []struct {
+		name string
+		a, b uuid.UUID
+	}{
+		{name: "already ordered", a: low, b: high},
+		{name: "reversed", a: high, b: low},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lockOrder(tt.a, tt.b)
+			if got[0] != low || got[1] != high {
+				t.Errorf("lockOrder(%s, %s) = %v, want [%s %s]", tt.a, tt.b, got, low, high)
+			}
+		})
+	}
+}
+
+// Opposite transfers must agree on the order, whatever the ids are.
+func TestLockOrderIsSymmetric(t *testing.T) {
+	for range 100 {
+		a, b := uuid.New(), uuid.New()
+		x, y := lockOrder(a, b), lockOrder(b, a)
+		if x[0] != y[0] || x[1] != y[1] {
+			t.Fatalf("lockOrder(%s, %s) = %v, lockOrder(%s, %s) = %v", a, b, x, b, a, y)
+		}
+	}
+}
+
+func TestTransferValidation(t *testing.T) {
+	svc := &Service{}
+	user := uuid.New()
+
+	if _, err := svc.Transfer(context.Background(), user, user, 10); !errors.Is(err, ErrSelfTransfer) {
+		t.Errorf("self transfer error = %v, want ErrSelfTransfer", err)
+	}
+	if _, err := svc.Transfer(context.Background(), user, uuid.New(), 0); err == nil {
+		t.Error("zero amount accepted")
+	}
+	if _, err := svc.Withdraw(context.Background(), user, -1); err == nil {
+		t.Error("negative withdrawal accepted")
+	}
+}
+
+func TestInsufficientFundsError(t *testing.T) {
+	var err error = &InsufficientFundsError{Available: 5, Requested: 10}
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Error("InsufficientFundsError does not match ErrInsufficientFunds")
+	}
+	if got, want := err.Error(), "insufficient_funds: available 5, requested 10"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -16,10 +16,11 @@ import (
 	"gozon/payments-service/internal/httpapi"
 	"gozon/payments-service/internal/payment"
 	"gozon/payments-service/internal/storage"
+	"gozon/pkg/auth"
 	"gozon/pkg/contracts"
 	"gozon/pkg/messaging"
-
-	"github.com/rabbitmq/amqp091-go"
+	"gozon/pkg/metrics"
+	"gozon/pkg/tracing"
 )
 
 type App struct {
@@ -27,54 +28,103 @@ type App struct {
 	logger    *slog.Logger
 	store     *storage.Store
 	accounts  *account.Service
+	reconcile *account.Reconciler
 	processor *payment.Processor
 	publisher messaging.Publisher
 	consumer  *messaging.Consumer
 	outbox    *messaging.OutboxDispatcher
+	janitor   *messaging.OutboxJanitor
 	httpSrv   *http.Server
 }
 
+// publicRoutes are served without credentials.
+var publicRoutes = []string{"GET /healthz", "GET /metrics"}
+
 func New(ctx context.Context, cfg config.Config, logger *slog.Logger) (*App, error) {
+	authn, err := auth.New(auth.Config{
+		Mode:     auth.Mode(cfg.AuthMode),
+		KeyFile:  cfg.AuthKeyFile,
+		JWKSFile: cfg.AuthJWKSFile,
+		Issuer:   cfg.AuthIssuer,
+		Audience: cfg.AuthAudience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init auth: %w", err)
+	}
+	if authn.Mode() == auth.ModeHeader {
+		logger.Warn("auth mode is header, X-User-ID is trusted as is; use for local development only")
+	}
+
 	store, err := storage.New(ctx, cfg.DatabaseURL)
 	if err != nil {
 		return nil, err
 	}
 
 	accounts := account.NewService(store.Pool())
-	processor := payment.NewProcessor(store.Pool(), logger)
+	reconcile := account.NewReconciler(store.Pool(), logger)
+	processor := payment.NewProcessor(store.Pool(), cfg.HoldTTL, logger)
 
-	publisher, err := messaging.NewRabbitPublisher(cfg.RabbitURL, cfg.PaymentsExchange)
+	encoding, err := messaging.ParseEncoding(cfg.EventEncoding)
 	if err != nil {
 		store.Close()
 		return nil, err
 	}
 
-	consumer, err := messaging.NewRabbitConsumer(cfg.RabbitURL, cfg.OrdersExchange, cfg.OrdersQueue, logger)
+	publisher, err := messaging.NewRabbitPublisher(cfg.RabbitURL, cfg.PaymentsExchange, cfg.PaymentsExchangeKind, encoding, logger)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	consumer, err := messaging.NewRabbitConsumer(cfg.RabbitURL, cfg.OrdersExchange, cfg.OrdersExchangeKind, cfg.OrdersQueue, messaging.ConsumerOptions{
+		Bindings: cfg.OrdersBindings,
+		Retry: messaging.RetryPolicy{
+			MaxAttempts: cfg.ConsumerMaxAttempts,
+			Delays:      cfg.ConsumerRetryDelays,
+		},
+		Workers: cfg.ConsumerWorkers,
+		Key:     messaging.DataKey("order_id"),
+	}, logger)
 	if err != nil {
 		store.Close()
 		publisher.Close()
 		return nil, err
 	}
 
-	api := httpapi.NewServer(accounts, logger)
+	deadLetters := messaging.NewDeadLetterAdmin(cfg.RabbitURL, cfg.OrdersQueue, store.Pool(), "payment_dead_letter_audit", contracts.Decode, logger)
+
+	api := httpapi.NewServer(accounts, reconcile, deadLetters, logger)
 	httpSrv := &http.Server{
 		Addr:    cfg.HTTPAddr,
-		Handler: api,
+		Handler: authn.Middleware(tracing.Middleware(metrics.InstrumentMux(api)), publicRoutes...),
 	}
 
-	outbox := messaging.NewOutboxDispatcher(store.Pool(), publisher, "payment_outbox", cfg.OutboxInterval, cfg.OutboxBatch, logger)
+	outbox := messaging.NewOutboxDispatcher(store.Pool(), publisher, "payment_outbox", cfg.OutboxInterval, cfg.OutboxBatch, cfg.OutboxMaxAttempts, cfg.OutboxLease, logger)
+	janitor := messaging.NewOutboxJanitor(store.Pool(), "payment_outbox", messaging.InboxTable{Name: "payment_inbox", TimeColumn: "processed_at"}, messaging.RetentionPolicy{
+		Interval:       cfg.JanitorInterval,
+		SentRetention:  cfg.OutboxRetention,
+		InboxRetention: cfg.InboxRetention,
+		BatchSize:      cfg.JanitorBatchSize,
+		Archive:        cfg.OutboxArchive,
+	}, logger)
 
-	return &App{
+	app := &App{
 		cfg:       cfg,
 		logger:    logger,
 		store:     store,
 		accounts:  accounts,
+		reconcile: reconcile,
 		processor: processor,
 		publisher: publisher,
 		consumer:  consumer,
 		outbox:    outbox,
+		janitor:   janitor,
 		httpSrv:   httpSrv,
-	}, nil
+	}
+	api.HandleFunc("GET /healthz", app.healthz)
+	api.HandleFunc("GET /metrics", metrics.Handler().ServeHTTP)
+
+	return app, nil
 }
 
 func (a *App) Run(ctx context.Context) error {
@@ -84,6 +134,11 @@ func (a *App) Run(ctx context.Context) error {
 	errCh := make(chan error, 2)
 
 	a.outbox.Start(ctx)
+	go a.janitor.Run(ctx)
+
+	go a.processor.RunHoldExpiry(ctx, a.cfg.HoldSweepInterval, a.cfg.OutboxBatch)
+
+	go a.reconcile.Run(ctx, a.cfg.ReconcileInterval, a.cfg.ReconcileRepair)
 
 	go func() {
 		errCh <- a.consumer.Start(ctx, a.handleOrderEvent)
@@ -108,26 +163,76 @@ func (a *App) Close(ctx context.Context) {
 	shutdownCtx, cancel := context.WithTimeout(ctx, a.cfg.ShutdownGracePeriod)
 	defer cancel()
 	_ = a.httpSrv.Shutdown(shutdownCtx)
-	a.consumer.Close()
+	a.consumer.Close(shutdownCtx)
 	a.publisher.Close()
 	a.store.Close()
 }
 
-func (a *App) handleOrderEvent(ctx context.Context, msg amqp091.Delivery) {
+func (a *App) handleOrderEvent(ctx context.Context, env contracts.Envelope) error {
+	switch env.Type {
+	case contracts.EventOrderCreated:
+		return a.handleOrderCreated(ctx, env)
+	case contracts.EventOrderCancelled:
+		return a.handleOrderCancelled(ctx, env)
+	case contracts.EventOrderCompleted:
+		return a.handleOrderCompleted(ctx, env)
+	}
+	return messaging.Permanent(fmt.Errorf("unexpected event type %q", env.Type))
+}
+
+func (a *App) handleOrderCreated(ctx context.Context, env contracts.Envelope) error {
 	var evt contracts.OrderCreatedEvent
-	if err := json.Unmarshal(msg.Body, &evt); err != nil {
-		a.logger.Error("invalid order event", "err", err)
-		_ = msg.Nack(false, false)
-		return
+	if err := json.Unmarshal(env.Data, &evt); err != nil {
+		return messaging.Permanent(fmt.Errorf("decode order event: %w", err))
 	}
 
 	if err := a.processor.HandleOrderCreated(ctx, evt); err != nil {
-		a.logger.Error("process order event", "order_id", evt.OrderID, "err", err)
-		_ = msg.Nack(false, true)
-		return
+		return fmt.Errorf("process order event: %w", err)
+	}
+	return nil
+}
+
+func (a *App) handleOrderCancelled(ctx context.Context, env contracts.Envelope) error {
+	var evt contracts.OrderCancelledEvent
+	if err := json.Unmarshal(env.Data, &evt); err != nil {
+		return messaging.Permanent(fmt.Errorf("decode order cancelled event: %w", err))
+	}
+
+	if err := a.processor.HandleOrderCancelled(ctx, evt); err != nil {
+		return fmt.Errorf("process order cancellation: %w", err)
+	}
+	return nil
+}
+
+func (a *App) handleOrderCompleted(ctx context.Context, env contracts.Envelope) error {
+	var evt contracts.OrderCompletedEvent
+	if err := json.Unmarshal(env.Data, &evt); err != nil {
+		return messaging.Permanent(fmt.Errorf("decode order completed event: %w", err))
 	}
 
-	_ = msg.Ack(false)
+	if err := a.processor.HandleOrderCompleted(ctx, evt); err != nil {
+		if errors.Is(err, payment.ErrNoActiveHold) {
+			// Redelivery cannot bring the hold back; leave the order to an
+			// operator via the dead-letter queue.
+			return messaging.Permanent(fmt.Errorf("capture payment: %w", err))
+		}
+		return fmt.Errorf("capture payment: %w", err)
+	}
+	return nil
+}
+
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	status := a.consumer.Status()
+	code := http.StatusOK
+	state := "ok"
+	if !a.consumer.Healthy() {
+		code = http.StatusServiceUnavailable
+		state = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": state, "consumer": status})
 }
 
 func Run() error {
@@ -136,6 +241,20 @@ func Run() error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: contracts.ProducerPayments,
+		Exporter:    cfg.TracingExporter,
+		File:        cfg.TracingFile,
+	})
+	if err != nil {
+		return fmt.Errorf("init tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.WithoutCancel(ctx)); err != nil {
+			logger.Warn("flush traces failed", "err", err)
+		}
+	}()
+
 	app, err := New(ctx, cfg, logger)
 	if err != nil {
 		return fmt.Errorf("init app: %w", err)
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gozon/payments-service/internal/config"
+	"gozon/payments-service/internal/storage"
+	"gozon/pkg/migrate"
+)
+
+// Migrate runs the migrate subcommand (up, down [N], status, redo) against
+// the configured database.
+func Migrate(args []string) error {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	cfg := config.Load()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := storage.Connect(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	migrator, err := storage.NewMigrator(pool, logger)
+	if err != nil {
+		return err
+	}
+	return migrate.Command(ctx, migrator, args, os.Stdout)
+}
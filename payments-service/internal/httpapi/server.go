@@ -3,25 +3,34 @@ package httpapi
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 
 	"gozon/payments-service/internal/account"
+	"gozon/pkg/auth"
+	"gozon/pkg/idempotency"
+	"gozon/pkg/messaging"
+	"gozon/pkg/messaging/dlqhttp"
 
 	"github.com/google/uuid"
 )
 
 type Server struct {
-	accounts *account.Service
-	logger   *slog.Logger
-	mux      *http.ServeMux
+	accounts    *account.Service
+	reconciler  *account.Reconciler
+	deadLetters *dlqhttp.Handler
+	logger      *slog.Logger
+	mux         *http.ServeMux
 }
 
-func NewServer(accounts *account.Service, logger *slog.Logger) *Server {
+func NewServer(accounts *account.Service, reconciler *account.Reconciler, deadLetters *messaging.DeadLetterAdmin, logger *slog.Logger) *Server {
 	s := &Server{
-		accounts: accounts,
-		logger:   logger,
-		mux:      http.NewServeMux(),
+		accounts:    accounts,
+		reconciler:  reconciler,
+		deadLetters: dlqhttp.New(deadLetters, logger),
+		logger:      logger,
+		mux:         http.NewServeMux(),
 	}
 	s.routes()
 	return s
@@ -30,17 +39,27 @@ func NewServer(accounts *account.Service, logger *slog.Logger) *Server {
 func (s *Server) routes() {
 	s.mux.HandleFunc("POST /accounts", s.createAccount)
 	s.mux.HandleFunc("POST /accounts/deposit", s.deposit)
+	s.mux.HandleFunc("POST /accounts/withdraw", s.withdraw)
+	s.mux.HandleFunc("POST /accounts/transfer", s.transfer)
 	s.mux.HandleFunc("GET /accounts/balance", s.balance)
+	s.mux.HandleFunc("GET /accounts/transactions", s.transactions)
+	s.mux.HandleFunc("GET /accounts/statement", s.statement)
+	s.mux.Handle("GET /admin/reconciliation", auth.RequireAdmin(http.HandlerFunc(s.reconciliation)))
+	s.deadLetters.Register(s.mux, auth.RequireAdmin)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
 func (s *Server) createAccount(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.userID(r)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 	if err := s.accounts.Create(r.Context(), userID); err != nil {
@@ -57,6 +76,16 @@ func (s *Server) createAccount(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) deposit(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.userID(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	idem, err := idempotency.FromRequest(r, userID.String(), body)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
@@ -64,15 +93,17 @@ func (s *Server) deposit(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Amount int64 `json:"amount"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid JSON body")
 		return
 	}
-	balance, err := s.accounts.Deposit(r.Context(), userID, req.Amount)
+	balance, err := s.accounts.Deposit(r.Context(), userID, req.Amount, idem)
 	if err != nil {
 		switch {
 		case errors.Is(err, account.ErrAccountNotFound):
 			writeError(w, http.StatusNotFound, "account not found")
+		case errors.Is(err, idempotency.ErrKeyReused):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
 		default:
 			writeError(w, http.StatusBadRequest, err.Error())
 		}
@@ -81,10 +112,74 @@ func (s *Server) deposit(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"balance": balance})
 }
 
-func (s *Server) balance(w http.ResponseWriter, r *http.Request) {
+func (s *Server) withdraw(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.userID(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var req struct {
+		Amount int64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	balance, err := s.accounts.Withdraw(r.Context(), userID, req.Amount)
+	if err != nil {
+		s.writeMovementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"balance": balance})
+}
+
+func (s *Server) transfer(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.userID(r)
 	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var req struct {
+		ToUserID string `json:"to_user_id"`
+		Amount   int64  `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	to, err := uuid.Parse(req.ToUserID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid to_user_id")
+		return
+	}
+	balance, err := s.accounts.Transfer(r.Context(), userID, to, req.Amount)
+	if err != nil {
+		s.writeMovementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"balance": balance})
+}
+
+func (s *Server) writeMovementError(w http.ResponseWriter, err error) {
+	var insufficient *account.InsufficientFundsError
+	switch {
+	case errors.As(err, &insufficient):
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":     account.ReasonInsufficientFunds,
+			"available": insufficient.Available,
+			"requested": insufficient.Requested,
+		})
+	case errors.Is(err, account.ErrAccountNotFound):
+		writeError(w, http.StatusNotFound, "account not found")
+	default:
 		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func (s *Server) balance(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.userID(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 	balance, err := s.accounts.GetBalance(r.Context(), userID)
@@ -97,15 +192,21 @@ func (s *Server) balance(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"balance": balance})
+	writeJSON(w, http.StatusOK, balance)
 }
 
-func (s *Server) userID(r *http.Request) (uuid.UUID, error) {
-	value := r.Header.Get("X-User-ID")
-	if value == "" {
-		return uuid.Nil, errors.New("missing X-User-ID header")
+func (s *Server) reconciliation(w http.ResponseWriter, r *http.Request) {
+	report, err := s.reconciler.Reconcile(r.Context(), false)
+	if err != nil {
+		s.logger.Error("reconciliation", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
 	}
-	return uuid.Parse(value)
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) userID(r *http.Request) (uuid.UUID, error) {
+	return auth.UserFrom(r.Context())
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
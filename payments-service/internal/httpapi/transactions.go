@@ -0,0 +1,158 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gozon/payments-service/internal/account"
+)
+
+func (s *Server) transactions(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.userID(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	filter := account.TransactionFilter{
+		Kind:   account.Kind(q.Get("kind")),
+		Cursor: q.Get("cursor"),
+	}
+	if filter.Kind != "" && !filter.Kind.Valid() {
+		writeError(w, http.StatusBadRequest, "invalid kind")
+		return
+	}
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		filter.Limit = limit
+	}
+	if filter.From, err = parseTimeParam(q, "from"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.To, err = parseTimeParam(q, "to"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := s.accounts.Transactions(r.Context(), userID, filter)
+	if err != nil {
+		switch {
+		case errors.Is(err, account.ErrAccountNotFound):
+			writeError(w, http.StatusNotFound, "account not found")
+		case errors.Is(err, account.ErrInvalidCursor):
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+		default:
+			s.logger.Error("list transactions", "err", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	var nextCursor any
+	if page.NextCursor != "" {
+		nextCursor = page.NextCursor
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"transactions": page.Transactions, "next_cursor": nextCursor})
+}
+
+func (s *Server) statement(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.userID(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+	from, err := parseTimeParam(q, "from")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseTimeParam(q, "to")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	st, err := s.accounts.Statement(r.Context(), userID, from, to)
+	if err != nil {
+		if errors.Is(err, account.ErrAccountNotFound) {
+			writeError(w, http.StatusNotFound, "account not found")
+			return
+		}
+		s.logger.Error("build statement", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, st)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="statement.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"transaction_id", "created_at", "kind", "order_id", "amount", "balance"})
+	_ = cw.Write([]string{"", formatTime(st.From), "opening_balance", "", "", strconv.FormatInt(st.OpeningBalance, 10)})
+	for _, line := range st.Lines {
+		orderID := ""
+		if line.OrderID != nil {
+			orderID = *line.OrderID
+		}
+		_ = cw.Write([]string{
+			line.ID,
+			line.CreatedAt.UTC().Format(time.RFC3339Nano),
+			string(line.Kind),
+			orderID,
+			strconv.FormatInt(line.Amount, 10),
+			strconv.FormatInt(line.Balance, 10),
+		})
+	}
+	_ = cw.Write([]string{"", formatTime(st.To), "closing_balance", "", "", strconv.FormatInt(st.ClosingBalance, 10)})
+	cw.Flush()
+}
+
+func parseTimeParam(q url.Values, key string) (*time.Time, error) {
+	raw := q.Get(key)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: expected RFC 3339 timestamp", key)
+	}
+	return &t, nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
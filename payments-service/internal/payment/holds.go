@@ -0,0 +1,262 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gozon/payments-service/internal/account"
+	"gozon/pkg/contracts"
+	"gozon/pkg/messaging"
+	"gozon/pkg/tracing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+// ErrNoActiveHold means an order was completed without an active hold to
+// capture, for example because the hold expired first. Goods must not ship
+// unpaid, so the event is not acknowledged as handled.
+var ErrNoActiveHold = errors.New("no active hold to capture")
+
+type hold struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Amount int64
+}
+
+func (p *Processor) HandleOrderCompleted(ctx context.Context, evt contracts.OrderCompletedEvent) (err error) {
+	ctx, span := tracer.Start(ctx, "payment.HandleOrderCompleted", trace.WithAttributes(attribute.String("order.id", evt.OrderID)))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+	orderID, err := uuid.Parse(evt.OrderID)
+	if err != nil {
+		return fmt.Errorf("invalid order id: %w", err)
+	}
+
+	tx, err := p.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO payment_inbox (event_id, event_type)
+		VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING`,
+		evt.EventID, contracts.EventOrderCompleted,
+	)
+	if err != nil {
+		return fmt.Errorf("insert inbox: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	h, err := lockActiveHold(ctx, tx, orderID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w for order %s", ErrNoActiveHold, orderID)
+		}
+		return err
+	}
+
+	if err := setHoldStatus(ctx, tx, h.ID, HoldCaptured); err != nil {
+		return err
+	}
+
+	_, err = account.Post(ctx, tx, account.Movement{
+		UserID:  h.UserID,
+		OrderID: &orderID,
+		Kind:    account.KindDebit,
+		Amount:  h.Amount,
+	})
+	if err != nil {
+		return fmt.Errorf("capture hold: %w", err)
+	}
+
+	if err := setPaymentStatus(ctx, tx, orderID, StatusSucceeded, ""); err != nil {
+		return err
+	}
+
+	p.logger.Info("hold captured", "order_id", orderID.String(), "user_id", h.UserID.String(), "amount", h.Amount)
+	return tx.Commit(ctx)
+}
+
+// voidHold releases the order's hold within tx; the caller commits.
+func (p *Processor) voidHold(ctx context.Context, tx pgx.Tx, evt contracts.OrderCancelledEvent, orderID uuid.UUID) error {
+	h, err := lockActiveHold(ctx, tx, orderID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			p.logger.Warn("no active hold to void", "order_id", orderID.String())
+			return nil
+		}
+		return err
+	}
+
+	if err := setHoldStatus(ctx, tx, h.ID, HoldVoided); err != nil {
+		return err
+	}
+	if err := setPaymentStatus(ctx, tx, orderID, StatusCancelled, "order_cancelled"); err != nil {
+		return err
+	}
+
+	result := contracts.PaymentVoidedEvent{
+		EventID:  uuid.New().String(),
+		OrderID:  evt.OrderID,
+		UserID:   evt.UserID,
+		Amount:   h.Amount,
+		VoidedAt: time.Now().UTC(),
+	}
+	if err := insertOutbox(ctx, tx, result.EventID, contracts.EventPaymentVoided, result); err != nil {
+		return err
+	}
+
+	p.logger.Info("hold voided", "order_id", orderID.String(), "user_id", h.UserID.String(), "amount", h.Amount)
+	return nil
+}
+
+func (p *Processor) RunHoldExpiry(ctx context.Context, interval time.Duration, batch int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := p.ExpireHolds(ctx, batch); err != nil {
+			p.logger.Error("expire holds failed", "err", err)
+		} else if n > 0 {
+			p.logger.Info("holds expired", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Processor) ExpireHolds(ctx context.Context, batch int) (int, error) {
+	tx, err := p.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, user_id, order_id, amount
+		FROM account_holds
+		WHERE status = $1 AND expires_at <= NOW()
+		ORDER BY expires_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`,
+		HoldActive, batch,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query expired holds: %w", err)
+	}
+
+	type expired struct {
+		hold
+		OrderID uuid.UUID
+	}
+	var items []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.ID, &e.UserID, &e.OrderID, &e.Amount); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		items = append(items, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for _, e := range items {
+		if err := setHoldStatus(ctx, tx, e.ID, HoldExpired); err != nil {
+			return 0, err
+		}
+		if err := setPaymentStatus(ctx, tx, e.OrderID, StatusExpired, "authorization_expired"); err != nil {
+			return 0, err
+		}
+
+		evt := contracts.PaymentExpiredEvent{
+			EventID:   uuid.New().String(),
+			OrderID:   e.OrderID.String(),
+			UserID:    e.UserID.String(),
+			Amount:    e.Amount,
+			ExpiredAt: now,
+		}
+		if err := insertOutbox(ctx, tx, evt.EventID, contracts.EventPaymentExpired, evt); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(items), tx.Commit(ctx)
+}
+
+// lockActiveHold ignores expires_at: a hold past its TTL stays active until
+// the expiry sweep reaches it, and a capture or void that gets there first
+// wins. The sweep skips rows locked here.
+func lockActiveHold(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) (hold, error) {
+	var h hold
+	err := tx.QueryRow(ctx, `
+		SELECT id, user_id, amount
+		FROM account_holds
+		WHERE order_id = $1 AND status = $2
+		FOR UPDATE`,
+		orderID, HoldActive,
+	).Scan(&h.ID, &h.UserID, &h.Amount)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return h, fmt.Errorf("select hold: %w", err)
+	}
+	return h, err
+}
+
+func setHoldStatus(ctx context.Context, tx pgx.Tx, holdID uuid.UUID, status string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE account_holds
+		SET status = $2, updated_at = NOW()
+		WHERE id = $1`,
+		holdID, status,
+	)
+	if err != nil {
+		return fmt.Errorf("update hold status: %w", err)
+	}
+	return nil
+}
+
+func setPaymentStatus(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, status Status, reason string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE payments
+		SET status = $2, reason = NULLIF($3, ''), updated_at = NOW()
+		WHERE order_id = $1`,
+		orderID, status, reason,
+	)
+	if err != nil {
+		return fmt.Errorf("update payment status: %w", err)
+	}
+	return nil
+}
+
+func insertOutbox(ctx context.Context, tx pgx.Tx, eventID, eventType string, event any) error {
+	env, err := contracts.NewEnvelope(ctx, contracts.ProducerPayments, eventType, eventID, event)
+	if err != nil {
+		return err
+	}
+	return messaging.InsertOutbox(ctx, tx, "payment_outbox", env)
+}
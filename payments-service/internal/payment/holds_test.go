@@ -0,0 +1,306 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"gozon/pkg/contracts"
+	"gozon/pkg/pgtest"
+
+	"github.com/google/uuid"
+)
+
+type fakeHold struct {
+	userID  uuid.UUID
+	orderID uuid.UUID
+	amount  int64
+	status  string
+	expired bool
+	// locked stands in for a row lock held by another transaction, which
+	// the expiry sweep skips.
+	locked bool
+}
+
+// fakeLedger scripts the statements the hold lifecycle runs against
+// in-memory holds, payments and accounts. Changes are applied as they run;
+// tests check db.Commits and db.Rollbacks to see whether they would have
+// stuck.
+type fakeLedger struct {
+	db *pgtest.DB
+
+	mu       sync.Mutex
+	holds    map[uuid.UUID]*fakeHold
+	payments map[uuid.UUID]Status
+	balance  int64
+	inbox    map[string]bool
+	postings []string
+	outbox   []string
+	// onLock runs when a capture or void locks a hold, before it changes
+	// anything.
+	onLock func()
+}
+
+func newFakeLedger(t *testing.T) (*fakeLedger, *Processor) {
+	t.Helper()
+	f := &fakeLedger{
+		db:       pgtest.New(),
+		holds:    map[uuid.UUID]*fakeHold{},
+		payments: map[uuid.UUID]Status{},
+		inbox:    map[string]bool{},
+		balance:  1000,
+	}
+	f.db.
+		On("INSERT INTO payment_inbox", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			id := args[0].(string)
+			if f.inbox[id] {
+				return pgtest.Affected(0)
+			}
+			f.inbox[id] = true
+			return pgtest.Affected(1)
+		}).
+		On("FROM account_holds WHERE order_id = $1 AND status = $2 FOR UPDATE", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			id, h := f.find(args[0].(uuid.UUID), args[1].(string))
+			if h == nil {
+				f.mu.Unlock()
+				return pgtest.Rows()
+			}
+			h.locked = true
+			onLock := f.onLock
+			f.mu.Unlock()
+			if onLock != nil {
+				onLock()
+			}
+			return pgtest.Row(id, h.userID, h.amount)
+		}).
+		On("FROM account_holds WHERE status = $1 AND expires_at <= NOW()", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			var rows [][]any
+			for id, h := range f.holds {
+				if h.status == args[0].(string) && h.expired && !h.locked {
+					rows = append(rows, []any{id, h.userID, h.orderID, h.amount})
+				}
+			}
+			return pgtest.Rows(rows...)
+		}).
+		On("UPDATE account_holds", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.holds[args[0].(uuid.UUID)].status = args[1].(string)
+			return pgtest.Affected(1)
+		}).
+		On("SELECT status, amount FROM payments", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			orderID := args[0].(uuid.UUID)
+			status, ok := f.payments[orderID]
+			if !ok {
+				return pgtest.Rows()
+			}
+			_, h := f.find(orderID, "")
+			return pgtest.Row(string(status), h.amount)
+		}).
+		On("UPDATE payments", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.payments[args[0].(uuid.UUID)] = args[1].(Status)
+			return pgtest.Affected(1)
+		}).
+		On("UPDATE accounts", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.balance += args[1].(int64)
+			return pgtest.Row(f.balance)
+		}).
+		On("INSERT INTO account_transactions", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.postings = append(f.postings, args[5].(string))
+			return pgtest.Affected(1)
+		}).
+		On("INSERT INTO ledger_entries", func([]any) pgtest.Result {
+			return pgtest.Affected(2)
+		}).
+		On("INSERT INTO payment_outbox", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			eventType, _ := contracts.ParseType(args[1].(string))
+			f.outbox = append(f.outbox, eventType)
+			return pgtest.Affected(1)
+		})
+	return f, NewProcessor(f.db, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// find returns the hold of orderID, restricted to status unless it is empty.
+// The caller holds f.mu.
+func (f *fakeLedger) find(orderID uuid.UUID, status string) (uuid.UUID, *fakeHold) {
+	for id, h := range f.holds {
+		if h.orderID == orderID && (status == "" || h.status == status) {
+			return id, h
+		}
+	}
+	return uuid.Nil, nil
+}
+
+// authorize adds an active hold and an authorized payment for a new order.
+func (f *fakeLedger) authorize(amount int64, expired bool) (orderID, userID uuid.UUID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	orderID, userID = uuid.New(), uuid.New()
+	f.holds[uuid.New()] = &fakeHold{userID: userID, orderID: orderID, amount: amount, status: HoldActive, expired: expired}
+	f.payments[orderID] = StatusAuthorized
+	return orderID, userID
+}
+
+func (f *fakeLedger) holdStatus(orderID uuid.UUID) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, h := f.find(orderID, "")
+	return h.status
+}
+
+func completed(orderID, userID uuid.UUID) contracts.OrderCompletedEvent {
+	return contracts.OrderCompletedEvent{EventID: uuid.NewString(), OrderID: orderID.String(), UserID: userID.String(), Amount: 300}
+}
+
+func TestCaptureHold(t *testing.T) {
+	f, p := newFakeLedger(t)
+	orderID, userID := f.authorize(300, false)
+	evt := completed(orderID, userID)
+
+	if err := p.HandleOrderCompleted(context.Background(), evt); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.holdStatus(orderID); got != HoldCaptured {
+		t.Errorf("hold status = %s, want %s", got, HoldCaptured)
+	}
+	if f.payments[orderID] != StatusSucceeded || f.balance != 700 {
+		t.Errorf("payment %s, balance %d, want succeeded and 700", f.payments[orderID], f.balance)
+	}
+	if len(f.postings) != 1 || f.postings[0] != "debit" || f.db.Commits() != 1 {
+		t.Errorf("postings %v after %d commits, want one debit", f.postings, f.db.Commits())
+	}
+
+	// A redelivery is recognised by the inbox and debits nothing.
+	if err := p.HandleOrderCompleted(context.Background(), evt); err != nil {
+		t.Fatalf("duplicate capture error = %v", err)
+	}
+	if len(f.postings) != 1 || f.balance != 700 {
+		t.Errorf("duplicate capture posted %v, balance %d", f.postings, f.balance)
+	}
+}
+
+func TestCaptureWithoutHold(t *testing.T) {
+	f, p := newFakeLedger(t)
+	orderID, userID := f.authorize(300, true)
+	if _, err := p.ExpireHolds(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	err := p.HandleOrderCompleted(context.Background(), completed(orderID, userID))
+	if !errors.Is(err, ErrNoActiveHold) {
+		t.Fatalf("capture of an expired hold error = %v, want ErrNoActiveHold", err)
+	}
+	// Nothing is committed, the inbox row included, so the message is
+	// retried or dead-lettered rather than silently acknowledged.
+	if f.db.Commits() != 1 || f.db.Rollbacks() != 1 {
+		t.Errorf("commits %d, rollbacks %d, want only the sweep committed", f.db.Commits(), f.db.Rollbacks())
+	}
+	if len(f.postings) != 0 || f.balance != 1000 {
+		t.Errorf("postings %v, balance %d, want no debit", f.postings, f.balance)
+	}
+}
+
+func TestVoidHold(t *testing.T) {
+	f, p := newFakeLedger(t)
+	orderID, userID := f.authorize(300, false)
+
+	err := p.HandleOrderCancelled(context.Background(), contracts.OrderCancelledEvent{
+		EventID: uuid.NewString(),
+		OrderID: orderID.String(),
+		UserID:  userID.String(),
+		Amount:  300,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.holdStatus(orderID); got != HoldVoided {
+		t.Errorf("hold status = %s, want %s", got, HoldVoided)
+	}
+	if f.payments[orderID] != StatusCancelled {
+		t.Errorf("payment status = %s, want %s", f.payments[orderID], StatusCancelled)
+	}
+	// The customer was never debited: no ledger movement and no refund.
+	if len(f.postings) != 0 || f.balance != 1000 {
+		t.Errorf("void posted %v, balance %d", f.postings, f.balance)
+	}
+	if len(f.outbox) != 1 || f.outbox[0] != contracts.EventPaymentVoided {
+		t.Errorf("outbox = %v, want [%s]", f.outbox, contracts.EventPaymentVoided)
+	}
+}
+
+func TestExpireHoldsRacingCapture(t *testing.T) {
+	f, p := newFakeLedger(t)
+	orderID, userID := f.authorize(300, true)
+	ctx := context.Background()
+
+	// The sweep runs while the capture holds the row lock and must skip it.
+	var swept int
+	f.onLock = func() {
+		f.onLock = nil
+		n, err := p.ExpireHolds(ctx, 10)
+		if err != nil {
+			t.Errorf("ExpireHolds() error = %v", err)
+		}
+		swept = n
+	}
+
+	if err := p.HandleOrderCompleted(ctx, completed(orderID, userID)); err != nil {
+		t.Fatal(err)
+	}
+	if swept != 0 {
+		t.Errorf("sweep expired %d holds under a capture's lock", swept)
+	}
+	if got := f.holdStatus(orderID); got != HoldCaptured || f.payments[orderID] != StatusSucceeded {
+		t.Errorf("hold %s, payment %s, want the capture to win", got, f.payments[orderID])
+	}
+	for _, e := range f.outbox {
+		if e == contracts.EventPaymentExpired {
+			t.Error("a captured order was also reported expired")
+		}
+	}
+
+	// Once the capture is done the hold is no longer active, so a later
+	// sweep leaves it alone too.
+	if n, err := p.ExpireHolds(ctx, 10); err != nil || n != 0 {
+		t.Errorf("ExpireHolds() after capture = %d, %v", n, err)
+	}
+}
+
+func TestExpireHolds(t *testing.T) {
+	f, p := newFakeLedger(t)
+	expiredOrder, _ := f.authorize(300, true)
+	liveOrder, _ := f.authorize(200, false)
+
+	n, err := p.ExpireHolds(context.Background(), 10)
+	if err != nil || n != 1 {
+		t.Fatalf("ExpireHolds() = %d, %v, want 1", n, err)
+	}
+	if f.holdStatus(expiredOrder) != HoldExpired || f.payments[expiredOrder] != StatusExpired {
+		t.Errorf("expired order: hold %s, payment %s", f.holdStatus(expiredOrder), f.payments[expiredOrder])
+	}
+	if f.holdStatus(liveOrder) != HoldActive {
+		t.Errorf("live hold status = %s, want it untouched", f.holdStatus(liveOrder))
+	}
+	if len(f.outbox) != 1 || f.outbox[0] != contracts.EventPaymentExpired {
+		t.Errorf("outbox = %v, want [%s]", f.outbox, contracts.EventPaymentExpired)
+	}
+}
@@ -2,40 +2,58 @@ package payment
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
+	"gozon/payments-service/internal/account"
 	"gozon/pkg/contracts"
+	"gozon/pkg/tracing"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Status string
 
 const (
 	StatusProcessing Status = "processing"
+	StatusAuthorized Status = "authorized"
 	StatusSucceeded  Status = "succeeded"
 	StatusFailed     Status = "failed"
+	StatusCancelled  Status = "cancelled"
+	StatusRefunded   Status = "refunded"
+	StatusExpired    Status = "expired"
 )
 
+// DB is the part of *pgxpool.Pool the processor uses.
+type DB interface {
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
 type Processor struct {
-	pool   *pgxpool.Pool
-	logger *slog.Logger
+	pool    DB
+	holdTTL time.Duration
+	logger  *slog.Logger
 }
 
-func NewProcessor(pool *pgxpool.Pool, logger *slog.Logger) *Processor {
+func NewProcessor(pool DB, holdTTL time.Duration, logger *slog.Logger) *Processor {
 	return &Processor{
-		pool:   pool,
-		logger: logger,
+		pool:    pool,
+		holdTTL: holdTTL,
+		logger:  logger,
 	}
 }
 
-func (p *Processor) HandleOrderCreated(ctx context.Context, evt contracts.OrderCreatedEvent) error {
+func (p *Processor) HandleOrderCreated(ctx context.Context, evt contracts.OrderCreatedEvent) (err error) {
+	ctx, span := tracer.Start(ctx, "payment.HandleOrderCreated", trace.WithAttributes(attribute.String("order.id", evt.OrderID)))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
 	userID, err := uuid.Parse(evt.UserID)
 	if err != nil {
 		return fmt.Errorf("invalid user id: %w", err)
@@ -55,7 +73,7 @@ func (p *Processor) HandleOrderCreated(ctx context.Context, evt contracts.OrderC
 		INSERT INTO payment_inbox (event_id, event_type)
 		VALUES ($1, $2)
 		ON CONFLICT (event_id) DO NOTHING`,
-		evt.EventID, "orders.created",
+		evt.EventID, contracts.EventOrderCreated,
 	)
 	if err != nil {
 		return fmt.Errorf("insert inbox: %w", err)
@@ -72,7 +90,7 @@ func (p *Processor) HandleOrderCreated(ctx context.Context, evt contracts.OrderC
 		orderID,
 	).Scan(&existing)
 	if err == nil {
-		if existing == StatusSucceeded || existing == StatusFailed {
+		if existing != StatusProcessing {
 			p.logger.Info("payment already processed", "order_id", orderID.String(), "status", existing)
 			return tx.Commit(ctx)
 		}
@@ -95,47 +113,28 @@ func (p *Processor) HandleOrderCreated(ctx context.Context, evt contracts.OrderC
 	reason := ""
 	success := false
 
-	var balance int64
-	err = tx.QueryRow(ctx, `
-		SELECT balance
-		FROM accounts
-		WHERE user_id = $1
-		FOR UPDATE`,
-		userID,
-	).Scan(&balance)
+	available, err := account.LockAvailable(ctx, tx, userID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
+		if errors.Is(err, account.ErrAccountNotFound) {
 			reason = "account_missing"
 		} else {
-			return fmt.Errorf("select balance: %w", err)
+			return err
 		}
-	} else if balance < evt.Amount {
-		reason = "insufficient_funds"
+	} else if available < evt.Amount {
+		reason = account.ReasonInsufficientFunds
 	} else {
-		tag, err := tx.Exec(ctx, `
-			UPDATE accounts
-			SET balance = balance - $2, updated_at = NOW()
-			WHERE user_id = $1`, userID, evt.Amount)
+		expiresAt := time.Now().Add(p.holdTTL)
+		_, err = tx.Exec(ctx, `
+			INSERT INTO account_holds (id, user_id, order_id, amount, status, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			uuid.New(), userID, orderID, evt.Amount, HoldActive, expiresAt,
+		)
 		if err != nil {
-			return fmt.Errorf("deduct balance: %w", err)
-		}
-		if tag.RowsAffected() == 0 {
-			reason = "account_missing"
-		} else {
-			success = true
-			status = StatusSucceeded
-			reason = ""
-			txID := uuid.New()
-			_, err = tx.Exec(ctx, `
-				INSERT INTO account_transactions (id, user_id, order_id, amount, kind)
-				VALUES ($1, $2, $3, $4, $5)`,
-				txID, userID, orderID, evt.Amount, "debit",
-			)
-			if err != nil {
-				return fmt.Errorf("insert account transaction: %w", err)
-			}
-			p.logger.Info("funds deducted", "order_id", orderID.String(), "user_id", userID.String(), "amount", evt.Amount)
+			return fmt.Errorf("insert hold: %w", err)
 		}
+		success = true
+		status = StatusAuthorized
+		p.logger.Info("funds held", "order_id", orderID.String(), "user_id", userID.String(), "amount", evt.Amount, "expires_at", expiresAt)
 	}
 
 	if !success {
@@ -169,19 +168,127 @@ func (p *Processor) HandleOrderCreated(ctx context.Context, evt contracts.OrderC
 		result.Reason = ""
 	}
 
-	payload, err := json.Marshal(result)
+	if err := insertOutbox(ctx, tx, result.EventID, contracts.EventPaymentProcessed, result); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (p *Processor) HandleOrderCancelled(ctx context.Context, evt contracts.OrderCancelledEvent) (err error) {
+	ctx, span := tracer.Start(ctx, "payment.HandleOrderCancelled", trace.WithAttributes(attribute.String("order.id", evt.OrderID)))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+	userID, err := uuid.Parse(evt.UserID)
+	if err != nil {
+		return fmt.Errorf("invalid user id: %w", err)
+	}
+	orderID, err := uuid.Parse(evt.OrderID)
+	if err != nil {
+		return fmt.Errorf("invalid order id: %w", err)
+	}
+
+	tx, err := p.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO payment_inbox (event_id, event_type)
+		VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING`,
+		evt.EventID, contracts.EventOrderCancelled,
+	)
+	if err != nil {
+		return fmt.Errorf("insert inbox: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	var (
+		existing Status
+		amount   int64
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT status, amount
+		FROM payments
+		WHERE order_id = $1
+		FOR UPDATE`,
+		orderID,
+	).Scan(&existing, &amount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO payments (order_id, user_id, amount, status, reason, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW(), NOW())`,
+			orderID, userID, evt.Amount, StatusCancelled, "order_cancelled",
+		)
+		if err != nil {
+			return fmt.Errorf("insert payment row: %w", err)
+		}
+		p.logger.Info("payment cancelled before processing", "order_id", orderID.String())
+		return tx.Commit(ctx)
+	}
 	if err != nil {
-		return fmt.Errorf("marshal payment event: %w", err)
+		return fmt.Errorf("select payment: %w", err)
+	}
+
+	if existing == StatusAuthorized {
+		if err := p.voidHold(ctx, tx, evt, orderID); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	if existing != StatusSucceeded {
+		_, err = tx.Exec(ctx, `
+			UPDATE payments
+			SET status = $2, reason = $3, updated_at = NOW()
+			WHERE order_id = $1 AND status = $4`,
+			orderID, StatusCancelled, "order_cancelled", StatusProcessing,
+		)
+		if err != nil {
+			return fmt.Errorf("update payment status: %w", err)
+		}
+		p.logger.Info("nothing to refund", "order_id", orderID.String(), "status", existing)
+		return tx.Commit(ctx)
+	}
+
+	_, err = account.Post(ctx, tx, account.Movement{
+		UserID:  userID,
+		OrderID: &orderID,
+		Kind:    account.KindRefund,
+		Amount:  amount,
+	})
+	if err != nil {
+		return fmt.Errorf("refund order %s: %w", orderID, err)
 	}
 
 	_, err = tx.Exec(ctx, `
-		INSERT INTO payment_outbox (event_id, event_type, payload)
-		VALUES ($1, $2, $3)`,
-		result.EventID, "payments.processed", payload,
+		UPDATE payments
+		SET status = $2, updated_at = NOW()
+		WHERE order_id = $1`,
+		orderID, StatusRefunded,
 	)
 	if err != nil {
-		return fmt.Errorf("insert outbox: %w", err)
+		return fmt.Errorf("update payment status: %w", err)
+	}
+
+	result := contracts.PaymentRefundedEvent{
+		EventID:  uuid.New().String(),
+		OrderID:  evt.OrderID,
+		UserID:   evt.UserID,
+		Amount:   amount,
+		Refunded: time.Now().UTC(),
+	}
+
+	if err := insertOutbox(ctx, tx, result.EventID, contracts.EventPaymentRefunded, result); err != nil {
+		return err
 	}
 
+	p.logger.Info("funds refunded", "order_id", orderID.String(), "user_id", userID.String(), "amount", amount)
 	return tx.Commit(ctx)
 }
@@ -0,0 +1,5 @@
+package payment
+
+import "go.opentelemetry.io/otel"
+
+var tracer = otel.Tracer("gozon/payments-service/payment")
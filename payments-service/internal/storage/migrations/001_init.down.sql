@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS payment_outbox;
+DROP TABLE IF EXISTS payment_inbox;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS account_transactions;
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS payment_idempotency (
+    user_id UUID NOT NULL,
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    response JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS account_holds (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES accounts(user_id),
+    order_id UUID NOT NULL UNIQUE,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    status TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS account_holds_active_idx ON account_holds (user_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS account_holds_expiry_idx ON account_holds (expires_at) WHERE status = 'active';
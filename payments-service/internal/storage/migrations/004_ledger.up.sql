@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id UUID NOT NULL,
+    account TEXT NOT NULL,
+    amount BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_account_idx ON ledger_entries (account);
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction_idx ON ledger_entries (transaction_id);
+
+INSERT INTO ledger_entries (transaction_id, account, amount, created_at)
+SELECT t.id, e.account, e.amount, t.created_at
+FROM account_transactions t
+CROSS JOIN LATERAL (VALUES
+    ('user:' || t.user_id::text, CASE WHEN t.kind = 'debit' THEN -t.amount ELSE t.amount END),
+    (CASE WHEN t.kind = 'deposit' THEN 'system:cash' ELSE 'system:revenue' END,
+     CASE WHEN t.kind = 'debit' THEN t.amount ELSE -t.amount END)
+) AS e(account, amount)
+WHERE NOT EXISTS (SELECT 1 FROM ledger_entries l WHERE l.transaction_id = t.id);
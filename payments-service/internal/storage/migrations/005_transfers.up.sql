@@ -0,0 +1 @@
+ALTER TABLE account_transactions ADD COLUMN IF NOT EXISTS counterparty_id UUID;
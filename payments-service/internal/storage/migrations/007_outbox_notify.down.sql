@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS payment_outbox_notify ON payment_outbox;
+DROP FUNCTION IF EXISTS notify_outbox();
@@ -0,0 +1,13 @@
+CREATE OR REPLACE FUNCTION notify_outbox() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify(TG_TABLE_NAME, '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS payment_outbox_notify ON payment_outbox;
+
+CREATE TRIGGER payment_outbox_notify
+    AFTER INSERT ON payment_outbox
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_outbox();
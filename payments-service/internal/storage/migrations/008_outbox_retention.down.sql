@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS payment_inbox_processed_idx;
+
+DROP TABLE IF EXISTS payment_outbox_archive;
+
+DROP INDEX IF EXISTS payment_outbox_sent_idx;
+
+ALTER TABLE payment_outbox DROP COLUMN IF EXISTS last_error;
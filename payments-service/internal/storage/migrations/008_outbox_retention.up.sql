@@ -0,0 +1,16 @@
+ALTER TABLE payment_outbox ADD COLUMN IF NOT EXISTS last_error TEXT;
+
+CREATE INDEX IF NOT EXISTS payment_outbox_sent_idx ON payment_outbox (updated_at) WHERE status = 'sent';
+
+CREATE TABLE IF NOT EXISTS payment_outbox_archive (
+    id BIGINT PRIMARY KEY,
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    sent_at TIMESTAMPTZ NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS payment_inbox_processed_idx ON payment_inbox (processed_at);
@@ -0,0 +1 @@
+ALTER TABLE payment_outbox DROP COLUMN trace_context;
@@ -0,0 +1 @@
+ALTER TABLE payment_outbox ADD COLUMN trace_context JSONB;
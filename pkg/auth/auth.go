@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Mode selects how the caller is identified.
+type Mode string
+
+const (
+	// ModeJWT requires a bearer token whose subject is the user UUID.
+	ModeJWT Mode = "jwt"
+	// ModeHeader trusts the X-User-ID header. It is meant for local
+	// development only: any client can impersonate any user.
+	ModeHeader Mode = "header"
+)
+
+const (
+	UserHeader  = "X-User-ID"
+	RolesHeader = "X-User-Roles"
+)
+
+// RoleAdmin grants access to the /admin routes and catalog changes.
+const RoleAdmin = "admin"
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrForbidden       = errors.New("insufficient permissions")
+)
+
+// Config describes where verification keys come from. KeyFile holds either
+// an HMAC secret (HS256) or a PEM RSA public key (RS256); JWKSFile holds a
+// JSON Web Key Set. Issuer and Audience are checked when set. Roles are read
+// from the "roles" claim.
+type Config struct {
+	Mode     Mode
+	KeyFile  string
+	JWKSFile string
+	Issuer   string
+	Audience string
+}
+
+type Authenticator struct {
+	mode   Mode
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+func New(cfg Config) (*Authenticator, error) {
+	switch cfg.Mode {
+	case ModeHeader:
+		return &Authenticator{mode: ModeHeader}, nil
+	case ModeJWT:
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Mode)
+	}
+
+	var keys *keySet
+	var err error
+	switch {
+	case cfg.KeyFile != "" && cfg.JWKSFile != "":
+		return nil, errors.New("set either a key file or a jwks file, not both")
+	case cfg.KeyFile != "":
+		keys, err = loadKeyFile(cfg.KeyFile)
+	case cfg.JWKSFile != "":
+		keys, err = loadJWKSFile(cfg.JWKSFile)
+	default:
+		return nil, errors.New("jwt auth needs a key file or a jwks file")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &Authenticator{
+		mode:   ModeJWT,
+		keys:   keys,
+		parser: jwt.NewParser(opts...),
+	}, nil
+}
+
+func (a *Authenticator) Mode() Mode {
+	return a.mode
+}
+
+// Middleware identifies the caller and stores the user ID and roles in the
+// request context. Every request must carry valid credentials except those
+// matching one of the public ServeMux patterns; on a public route
+// credentials are optional but still rejected when invalid.
+func (a *Authenticator) Middleware(next http.Handler, public ...string) http.Handler {
+	open := http.NewServeMux()
+	for _, pattern := range public {
+		open.Handle(pattern, next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, roles, ok, err := a.identify(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if !ok {
+			if _, pattern := open.Handler(r); pattern == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, ErrUnauthenticated.Error())
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := WithRoles(WithUser(r.Context(), userID), roles...)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *Authenticator) identify(r *http.Request) (uuid.UUID, []string, bool, error) {
+	if a.mode == ModeHeader {
+		value := r.Header.Get(UserHeader)
+		if value == "" {
+			return uuid.UUID{}, nil, false, nil
+		}
+		userID, err := uuid.Parse(value)
+		if err != nil {
+			return uuid.UUID{}, nil, false, fmt.Errorf("invalid %s header", UserHeader)
+		}
+		return userID, parseRoles(r.Header.Get(RolesHeader)), true, nil
+	}
+
+	raw := bearerToken(r)
+	if raw == "" {
+		return uuid.UUID{}, nil, false, nil
+	}
+	userID, roles, err := a.Verify(raw)
+	if err != nil {
+		return uuid.UUID{}, nil, false, err
+	}
+	return userID, roles, true, nil
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Verify checks the token signature and claims and returns its subject and
+// roles.
+func (a *Authenticator) Verify(raw string) (uuid.UUID, []string, error) {
+	if a.mode != ModeJWT {
+		return uuid.UUID{}, nil, fmt.Errorf("%w: jwt auth is disabled", ErrInvalidToken)
+	}
+	var c claims
+	if _, err := a.parser.ParseWithClaims(raw, &c, a.keys.lookup); err != nil {
+		return uuid.UUID{}, nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	userID, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.UUID{}, nil, fmt.Errorf("%w: subject is not a user id", ErrInvalidToken)
+	}
+	return userID, c.Roles, nil
+}
+
+// RequireAdmin lets through only callers with RoleAdmin.
+func RequireAdmin(next http.Handler) http.Handler {
+	return RequireRole(RoleAdmin, next)
+}
+
+// RequireRole answers 401 to anonymous callers and 403 to callers without
+// role.
+func RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := UserFrom(r.Context()); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if !HasRole(r.Context(), role) {
+			writeError(w, http.StatusForbidden, ErrForbidden.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func parseRoles(header string) []string {
+	var roles []string
+	for _, role := range strings.Split(header, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// bearerToken reads the Authorization header. Browsers cannot set headers
+// on WebSocket handshakes, so upgrades may pass the token as access_token.
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+type (
+	userKey  struct{}
+	rolesKey struct{}
+)
+
+func WithUser(ctx context.Context, userID uuid.UUID) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// UserFrom returns the authenticated user or ErrUnauthenticated.
+func UserFrom(ctx context.Context) (uuid.UUID, error) {
+	userID, ok := ctx.Value(userKey{}).(uuid.UUID)
+	if !ok {
+		return uuid.UUID{}, ErrUnauthenticated
+	}
+	return userID, nil
+}
+
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, rolesKey{}, roles)
+}
+
+// RolesFrom returns the roles of the authenticated user.
+func RolesFrom(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey{}).([]string)
+	return roles
+}
+
+func HasRole(ctx context.Context, role string) bool {
+	return slices.Contains(RolesFrom(ctx), role)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
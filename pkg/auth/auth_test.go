@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, c jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func validClaims(sub string) jwt.MapClaims {
+	return jwt.MapClaims{"sub": sub, "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestVerifyHS256(t *testing.T) {
+	authn, err := New(Config{Mode: ModeJWT, KeyFile: writeFile(t, "secret", testSecret), Issuer: "gozon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+
+	withIssuer := func(c jwt.MapClaims) jwt.MapClaims {
+		c["iss"] = "gozon"
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		roles   []string
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			token: sign(t, jwt.SigningMethodHS256, testSecret, "", withIssuer(validClaims(userID.String()))),
+		},
+		{
+			name: "roles",
+			token: sign(t, jwt.SigningMethodHS256, testSecret, "", withIssuer(jwt.MapClaims{
+				"sub": userID.String(), "exp": time.Now().Add(time.Hour).Unix(), "roles": []string{RoleAdmin},
+			})),
+			roles: []string{RoleAdmin},
+		},
+		{
+			name: "expired",
+			token: sign(t, jwt.SigningMethodHS256, testSecret, "", withIssuer(jwt.MapClaims{
+				"sub": userID.String(), "exp": time.Now().Add(-time.Minute).Unix(),
+			})),
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			token:   sign(t, jwt.SigningMethodHS256, testSecret, "", withIssuer(jwt.MapClaims{"sub": userID.String()})),
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			token:   sign(t, jwt.SigningMethodHS256, testSecret, "", validClaims(userID.String())),
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			token:   sign(t, jwt.SigningMethodHS256, []byte("ffffffffffffffffffffffffffffffff"), "", withIssuer(validClaims(userID.String()))),
+			wantErr: true,
+		},
+		{
+			name:    "subject is not a uuid",
+			token:   sign(t, jwt.SigningMethodHS256, testSecret, "", withIssuer(validClaims("alice"))),
+			wantErr: true,
+		},
+		{
+			name:    "alg none",
+			token:   sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", withIssuer(validClaims(userID.String()))),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, roles, err := authn.Verify(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if got != userID {
+				t.Errorf("Verify() user = %s, want %s", got, userID)
+			}
+			if strings.Join(roles, ",") != strings.Join(tt.roles, ",") {
+				t.Errorf("Verify() roles = %v, want %v", roles, tt.roles)
+			}
+		})
+	}
+}
+
+func TestVerifyRS256RejectsKeyConfusion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	authn, err := New(Config{Mode: ModeJWT, KeyFile: writeFile(t, "key.pem", pemKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+
+	if _, _, err := authn.Verify(sign(t, jwt.SigningMethodRS256, key, "", validClaims(userID.String()))); err != nil {
+		t.Fatalf("RS256 token rejected: %v", err)
+	}
+	// An HS256 token signed with the public key bytes must not verify.
+	forged := sign(t, jwt.SigningMethodHS256, pemKey, "", validClaims(userID.String()))
+	if _, _, err := authn.Verify(forged); err == nil {
+		t.Fatal("HS256 token signed with the public key was accepted")
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	jwks := func(keys ...map[string]string) []byte {
+		raw, _ := json.Marshal(map[string]any{"keys": keys})
+		return raw
+	}
+	oct := func(kid string, secret []byte) map[string]string {
+		return map[string]string{"kty": "oct", "kid": kid, "k": base64.RawURLEncoding.EncodeToString(secret)}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     func(t *testing.T) Config
+		wantErr bool
+	}{
+		{
+			name: "hmac secret",
+			cfg: func(t *testing.T) Config {
+				return Config{Mode: ModeJWT, KeyFile: writeFile(t, "secret", testSecret)}
+			},
+		},
+		{
+			name: "short hmac secret",
+			cfg: func(t *testing.T) Config {
+				return Config{Mode: ModeJWT, KeyFile: writeFile(t, "secret", []byte("short"))}
+			},
+			wantErr: true,
+		},
+		{
+			name: "jwks oct",
+			cfg: func(t *testing.T) Config {
+				return Config{Mode: ModeJWT, JWKSFile: writeFile(t, "jwks.json", jwks(oct("a", testSecret)))}
+			},
+		},
+		{
+			name: "short jwks oct",
+			cfg: func(t *testing.T) Config {
+				return Config{Mode: ModeJWT, JWKSFile: writeFile(t, "jwks.json", jwks(oct("a", []byte("short"))))}
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty jwks",
+			cfg: func(t *testing.T) Config {
+				return Config{Mode: ModeJWT, JWKSFile: writeFile(t, "jwks.json", jwks())}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no keys",
+			cfg:     func(t *testing.T) Config { return Config{Mode: ModeJWT} },
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			cfg:     func(t *testing.T) Config { return Config{Mode: "basic"} },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg(t))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKSLooksUpKeyByKID(t *testing.T) {
+	other := []byte("abcdefghijklmnopqrstuvwxyz012345")
+	raw, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "a", "k": base64.RawURLEncoding.EncodeToString(testSecret)},
+		{"kty": "oct", "kid": "b", "k": base64.RawURLEncoding.EncodeToString(other)},
+	}})
+	authn, err := New(Config{Mode: ModeJWT, JWKSFile: writeFile(t, "jwks.json", raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := uuid.NewString()
+
+	if _, _, err := authn.Verify(sign(t, jwt.SigningMethodHS256, other, "b", validClaims(sub))); err != nil {
+		t.Errorf("kid b: %v", err)
+	}
+	if _, _, err := authn.Verify(sign(t, jwt.SigningMethodHS256, other, "a", validClaims(sub))); err == nil {
+		t.Error("token signed with key b accepted under kid a")
+	}
+	if _, _, err := authn.Verify(sign(t, jwt.SigningMethodHS256, testSecret, "c", validClaims(sub))); err == nil {
+		t.Error("unknown kid accepted")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	authn, err := New(Config{Mode: ModeHeader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /orders", func(w http.ResponseWriter, r *http.Request) {
+		got, err := UserFrom(r.Context())
+		if err != nil || got != userID {
+			t.Errorf("UserFrom() = %s, %v", got, err)
+		}
+	})
+	mux.Handle("GET /admin/x", RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	handler := authn.Middleware(mux, "GET /healthz")
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "public without credentials", method: http.MethodGet, path: "/healthz", want: http.StatusOK},
+		{name: "public with bad credentials", method: http.MethodGet, path: "/healthz", headers: map[string]string{UserHeader: "x"}, want: http.StatusUnauthorized},
+		{name: "public pattern is method specific", method: http.MethodPost, path: "/healthz", want: http.StatusUnauthorized},
+		{name: "private without credentials", method: http.MethodGet, path: "/orders", want: http.StatusUnauthorized},
+		{name: "unknown route without credentials", method: http.MethodGet, path: "/nope", want: http.StatusUnauthorized},
+		{name: "private with user", method: http.MethodGet, path: "/orders", headers: map[string]string{UserHeader: userID.String()}, want: http.StatusOK},
+		{name: "admin without role", method: http.MethodGet, path: "/admin/x", headers: map[string]string{UserHeader: userID.String()}, want: http.StatusForbidden},
+		{name: "admin with other role", method: http.MethodGet, path: "/admin/x", headers: map[string]string{UserHeader: userID.String(), RolesHeader: "support"}, want: http.StatusForbidden},
+		{name: "admin with role", method: http.MethodGet, path: "/admin/x", headers: map[string]string{UserHeader: userID.String(), RolesHeader: "support, admin"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "header", target: "/", headers: map[string]string{"Authorization": "Bearer abc"}, want: "abc"},
+		{name: "scheme is case insensitive", target: "/", headers: map[string]string{"Authorization": "bearer abc"}, want: "abc"},
+		{name: "other scheme", target: "/", headers: map[string]string{"Authorization": "Basic abc"}, want: ""},
+		{name: "query on websocket upgrade", target: "/ws?access_token=abc", headers: map[string]string{"Upgrade": "websocket"}, want: "abc"},
+		{name: "query on plain request", target: "/ws?access_token=abc", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := bearerToken(req); got != tt.want {
+				t.Errorf("bearerToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
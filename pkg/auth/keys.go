@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// minSecretLength is the HS256 key size; shorter secrets can be brute
+// forced offline from any issued token.
+const minSecretLength = 32
+
+var errShortSecret = fmt.Errorf("hmac secret must be at least %d bytes", minSecretLength)
+
+// keySet holds verification keys: HS256 secrets as []byte and RS256 public
+// keys as *rsa.PublicKey. Keys loaded from a JWKS file are indexed by kid.
+type keySet struct {
+	byKID map[string]any
+	def   any
+}
+
+// loadKeyFile reads a PEM-encoded RSA public key, or otherwise treats the
+// file contents as an HMAC secret.
+func loadKeyFile(path string) (*keySet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	if bytes.Contains(raw, []byte("-----BEGIN")) {
+		key, err := jwt.ParseRSAPublicKeyFromPEM(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		return &keySet{def: key}, nil
+	}
+
+	secret := bytes.TrimSpace(raw)
+	if len(secret) < minSecretLength {
+		return nil, errShortSecret
+	}
+	return &keySet{def: secret}, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+func loadJWKSFile(path string) (*keySet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file: %w", err)
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	set := &keySet{byKID: make(map[string]any)}
+	for i, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %d: %w", i, err)
+		}
+		set.byKID[k.Kid] = key
+	}
+	if len(set.byKID) == 0 {
+		return nil, errors.New("jwks has no signing keys")
+	}
+	if len(set.byKID) == 1 {
+		for _, key := range set.byKID {
+			set.def = key
+		}
+	}
+	return set, nil
+}
+
+func (k jwk) key() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decode modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decode exponent: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, fmt.Errorf("decode secret: %w", err)
+		}
+		if len(secret) < minSecretLength {
+			return nil, errShortSecret
+		}
+		return secret, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// lookup returns the key for token, checking that its algorithm matches the
+// key type so that an RSA public key is never used as an HMAC secret.
+func (s *keySet) lookup(token *jwt.Token) (any, error) {
+	key := s.def
+	if kid, _ := token.Header["kid"].(string); kid != "" && s.byKID != nil {
+		k, ok := s.byKID[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: kid %q", ErrUnknownKey, kid)
+		}
+		key = k
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	switch key.(type) {
+	case []byte:
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+	case *rsa.PublicKey:
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+	}
+	return key, nil
+}
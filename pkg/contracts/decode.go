@@ -0,0 +1,34 @@
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownEvent = errors.New("unknown event type")
+
+var eventTypes = map[string]func() any{
+	EventOrderCreated:     func() any { return &OrderCreatedEvent{} },
+	EventOrderCancelled:   func() any { return &OrderCancelledEvent{} },
+	EventOrderCompleted:   func() any { return &OrderCompletedEvent{} },
+	EventPaymentProcessed: func() any { return &PaymentProcessedEvent{} },
+	EventPaymentRefunded:  func() any { return &PaymentRefundedEvent{} },
+	EventPaymentExpired:   func() any { return &PaymentExpiredEvent{} },
+	EventPaymentVoided:    func() any { return &PaymentVoidedEvent{} },
+}
+
+// Decode unmarshals body into the current contract struct for eventType.
+// The body must already be upcast to the latest version.
+func Decode(eventType string, body []byte) (any, error) {
+	eventType, _ = ParseType(eventType)
+	newEvent, ok := eventTypes[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEvent, eventType)
+	}
+	evt := newEvent()
+	if err := json.Unmarshal(body, evt); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", eventType, err)
+	}
+	return evt, nil
+}
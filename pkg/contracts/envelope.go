@@ -0,0 +1,83 @@
+package contracts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ProducerOrders   = "orders-service"
+	ProducerPayments = "payments-service"
+)
+
+// DefaultSchemaVersion is assumed for messages that carry no version.
+const DefaultSchemaVersion = 1
+
+var ErrNotEnvelope = errors.New("message is not an event envelope")
+
+// Envelope wraps every published event. The event itself travels in Data;
+// the remaining fields describe it and are mirrored to AMQP properties.
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	CausationID   string          `json:"causation_id,omitempty"`
+	Producer      string          `json:"producer"`
+	Data          json.RawMessage `json:"data"`
+}
+
+type causeKey struct{}
+
+// WithCause records the envelope being handled so that events emitted
+// while handling it inherit its correlation ID and point back at it.
+func WithCause(ctx context.Context, cause Envelope) context.Context {
+	return context.WithValue(ctx, causeKey{}, cause)
+}
+
+func CauseFrom(ctx context.Context) (Envelope, bool) {
+	cause, ok := ctx.Value(causeKey{}).(Envelope)
+	return cause, ok
+}
+
+func NewEnvelope(ctx context.Context, producer, eventType, id string, event any) (Envelope, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("marshal %s event: %w", eventType, err)
+	}
+
+	version := LatestVersion(eventType)
+	env := Envelope{
+		ID:            id,
+		Type:          VersionedType(eventType, version),
+		SchemaVersion: version,
+		OccurredAt:    time.Now().UTC(),
+		CorrelationID: id,
+		Producer:      producer,
+		Data:          data,
+	}
+	if cause, ok := CauseFrom(ctx); ok {
+		env.CausationID = cause.ID
+		if cause.CorrelationID != "" {
+			env.CorrelationID = cause.CorrelationID
+		}
+	}
+	return env, nil
+}
+
+// UnmarshalEnvelope parses body as an envelope and returns ErrNotEnvelope
+// for bare event JSON published before envelopes were introduced.
+func UnmarshalEnvelope(body []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return Envelope{}, err
+	}
+	if env.Type == "" || len(env.Data) == 0 {
+		return Envelope{}, ErrNotEnvelope
+	}
+	return env, nil
+}
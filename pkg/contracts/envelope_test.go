@@ -0,0 +1,69 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNewEnvelopeInheritsCause(t *testing.T) {
+	event := OrderCreatedEvent{EventID: "evt-1", OrderID: "o-1"}
+
+	root, err := NewEnvelope(context.Background(), ProducerOrders, EventOrderCreated, "evt-1", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.CorrelationID != "evt-1" || root.CausationID != "" {
+		t.Errorf("root correlation %q, causation %q", root.CorrelationID, root.CausationID)
+	}
+	if root.SchemaVersion != LatestVersion(EventOrderCreated) || root.Producer != ProducerOrders {
+		t.Errorf("root = %+v", root)
+	}
+
+	child, err := NewEnvelope(WithCause(context.Background(), root), ProducerPayments, EventPaymentProcessed, "evt-2", struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.CorrelationID != "evt-1" || child.CausationID != "evt-1" {
+		t.Errorf("child correlation %q, causation %q, want evt-1 for both", child.CorrelationID, child.CausationID)
+	}
+
+	grandchild, err := NewEnvelope(WithCause(context.Background(), child), ProducerOrders, EventOrderCancelled, "evt-3", struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grandchild.CorrelationID != "evt-1" || grandchild.CausationID != "evt-2" {
+		t.Errorf("grandchild correlation %q, causation %q", grandchild.CorrelationID, grandchild.CausationID)
+	}
+}
+
+func TestUnmarshalEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+		anyErr  bool
+	}{
+		{name: "envelope", body: `{"id":"e","type":"order.created","schema_version":1,"data":{"order_id":"o"}}`},
+		{name: "bare event", body: `{"event_id":"e","order_id":"o"}`, wantErr: ErrNotEnvelope},
+		{name: "type without data", body: `{"type":"order.created"}`, wantErr: ErrNotEnvelope},
+		{name: "not json", body: `{`, anyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UnmarshalEnvelope([]byte(tt.body))
+			switch {
+			case tt.anyErr:
+				if err == nil || errors.Is(err, ErrNotEnvelope) {
+					t.Errorf("UnmarshalEnvelope() error = %v, want a decode error", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("UnmarshalEnvelope() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("UnmarshalEnvelope() error = %v", err)
+			}
+		})
+	}
+}
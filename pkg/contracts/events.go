@@ -2,12 +2,46 @@ package contracts
 
 import "time"
 
+const (
+	EventOrderCreated     = "orders.created"
+	EventOrderCancelled   = "orders.cancelled"
+	EventOrderCompleted   = "orders.completed"
+	EventPaymentProcessed = "payments.processed"
+	EventPaymentRefunded  = "payments.refunded"
+	EventPaymentExpired   = "payments.expired"
+	EventPaymentVoided    = "payments.voided"
+)
+
+type OrderItem struct {
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"`
+}
+
 type OrderCreatedEvent struct {
-	EventID   string    `json:"event_id"`
-	OrderID   string    `json:"order_id"`
-	UserID    string    `json:"user_id"`
-	Amount    int64     `json:"amount"`
-	CreatedAt time.Time `json:"created_at"`
+	EventID   string      `json:"event_id"`
+	OrderID   string      `json:"order_id"`
+	UserID    string      `json:"user_id"`
+	Amount    int64       `json:"amount"`
+	Items     []OrderItem `json:"items,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+type OrderCancelledEvent struct {
+	EventID     string    `json:"event_id"`
+	OrderID     string    `json:"order_id"`
+	UserID      string    `json:"user_id"`
+	Amount      int64     `json:"amount"`
+	CancelledAt time.Time `json:"cancelled_at"`
+}
+
+type OrderCompletedEvent struct {
+	EventID     string    `json:"event_id"`
+	OrderID     string    `json:"order_id"`
+	UserID      string    `json:"user_id"`
+	Amount      int64     `json:"amount"`
+	CompletedAt time.Time `json:"completed_at"`
 }
 
 type PaymentStatus string
@@ -26,3 +60,30 @@ type PaymentProcessedEvent struct {
 	Reason    string        `json:"reason,omitempty"`
 	Processed time.Time     `json:"processed_at"`
 }
+
+type PaymentRefundedEvent struct {
+	EventID  string    `json:"event_id"`
+	OrderID  string    `json:"order_id"`
+	UserID   string    `json:"user_id"`
+	Amount   int64     `json:"amount"`
+	Refunded time.Time `json:"refunded_at"`
+}
+
+type PaymentExpiredEvent struct {
+	EventID   string    `json:"event_id"`
+	OrderID   string    `json:"order_id"`
+	UserID    string    `json:"user_id"`
+	Amount    int64     `json:"amount"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// PaymentVoidedEvent reports that the hold of a cancelled order was
+// released before it was captured. Nothing was debited, so there is nothing
+// to refund.
+type PaymentVoidedEvent struct {
+	EventID  string    `json:"event_id"`
+	OrderID  string    `json:"order_id"`
+	UserID   string    `json:"user_id"`
+	Amount   int64     `json:"amount"`
+	VoidedAt time.Time `json:"voided_at"`
+}
@@ -0,0 +1,162 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported event schema version")
+
+// Upcaster converts a payload of one schema version to the next one.
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+type eventSchema struct {
+	latest    int
+	upcasters map[int]Upcaster
+	samples   map[int]json.RawMessage
+}
+
+var schemas = map[string]*eventSchema{}
+
+func registerSchema(eventType string, latest int) {
+	schemas[eventType] = &eventSchema{
+		latest:    latest,
+		upcasters: map[int]Upcaster{},
+		samples:   map[int]json.RawMessage{},
+	}
+}
+
+func registerUpcaster(eventType string, from int, up Upcaster) {
+	schemas[eventType].upcasters[from] = up
+}
+
+func registerSample(eventType string, version int, sample string) {
+	schemas[eventType].samples[version] = json.RawMessage(sample)
+}
+
+func init() {
+	for _, eventType := range []string{
+		EventOrderCreated,
+		EventOrderCancelled,
+		EventOrderCompleted,
+		EventPaymentProcessed,
+		EventPaymentRefunded,
+		EventPaymentExpired,
+		EventPaymentVoided,
+	} {
+		registerSchema(eventType, 1)
+	}
+
+	const ids = `"event_id":"8d0f5a4e-3a61-4c55-9c2a-0b7c3f3e1a01","order_id":"5b1e7c0a-2f4d-4b8e-9a6c-1d2e3f4a5b6c","user_id":"11111111-1111-1111-1111-111111111111","amount":100000`
+	registerSample(EventOrderCreated, 1, `{`+ids+`,"items":[{"sku":"book-001","name":"Book","quantity":2,"unit_price":50000}],"created_at":"2024-01-01T00:00:00Z"}`)
+	registerSample(EventOrderCancelled, 1, `{`+ids+`,"cancelled_at":"2024-01-01T00:00:00Z"}`)
+	registerSample(EventOrderCompleted, 1, `{`+ids+`,"completed_at":"2024-01-01T00:00:00Z"}`)
+	registerSample(EventPaymentProcessed, 1, `{`+ids+`,"status":"failed","reason":"insufficient_funds","processed_at":"2024-01-01T00:00:00Z"}`)
+	registerSample(EventPaymentRefunded, 1, `{`+ids+`,"refunded_at":"2024-01-01T00:00:00Z"}`)
+	registerSample(EventPaymentExpired, 1, `{`+ids+`,"expired_at":"2024-01-01T00:00:00Z"}`)
+	registerSample(EventPaymentVoided, 1, `{`+ids+`,"voided_at":"2024-01-01T00:00:00Z"}`)
+}
+
+// LatestVersion returns the schema version producers emit for eventType,
+// or 1 for types without a registered schema.
+func LatestVersion(eventType string) int {
+	if schema, ok := schemas[eventType]; ok {
+		return schema.latest
+	}
+	return 1
+}
+
+// VersionedType returns the wire type for eventType at version, for
+// example "orders.created.v1".
+func VersionedType(eventType string, version int) string {
+	return eventType + ".v" + strconv.Itoa(version)
+}
+
+// ParseType splits a wire type into its base type and version. The suffix
+// must be ".v" followed only by digits; anything else, such as
+// "orders.v2created", is a type without a version and returns version 0.
+func ParseType(wireType string) (string, int) {
+	i := strings.LastIndex(wireType, ".v")
+	if i <= 0 {
+		return wireType, 0
+	}
+	digits := wireType[i+2:]
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return wireType, 0
+	}
+	version, err := strconv.Atoi(digits)
+	if err != nil || version < 1 {
+		return wireType, 0
+	}
+	return wireType[:i], version
+}
+
+// Upcast converts env to the latest schema version of its type and strips
+// the version suffix, so handlers only deal with base types and the
+// current structs.
+func Upcast(env Envelope) (Envelope, error) {
+	base, version := ParseType(env.Type)
+	if version == 0 {
+		version = env.SchemaVersion
+	}
+	if version == 0 {
+		version = 1
+	}
+
+	env.Type = base
+	schema, ok := schemas[base]
+	if !ok {
+		env.SchemaVersion = version
+		return env, nil
+	}
+	if version > schema.latest {
+		return Envelope{}, fmt.Errorf("%w: %s v%d, latest known is v%d", ErrUnsupportedVersion, base, version, schema.latest)
+	}
+
+	for ; version < schema.latest; version++ {
+		up, ok := schema.upcasters[version]
+		if !ok {
+			return Envelope{}, fmt.Errorf("%w: no upcaster for %s v%d", ErrUnsupportedVersion, base, version)
+		}
+		data, err := up(env.Data)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("upcast %s v%d: %w", base, version, err)
+		}
+		env.Data = data
+	}
+	env.SchemaVersion = schema.latest
+	return env, nil
+}
+
+// CheckVersions upcasts the registered sample of every version of every
+// event type and decodes it strictly into the current struct. It fails if
+// a version has no sample, an upcaster is missing or a decoded payload
+// loses fields. It is meant for tests; bumping a version without a sample
+// and an upcaster fails them.
+func CheckVersions() error {
+	for eventType, schema := range schemas {
+		for version := 1; version <= schema.latest; version++ {
+			sample, ok := schema.samples[version]
+			if !ok {
+				return fmt.Errorf("%s v%d: no sample registered", eventType, version)
+			}
+
+			env, err := Upcast(Envelope{Type: VersionedType(eventType, version), Data: sample})
+			if err != nil {
+				return fmt.Errorf("%s v%d: %w", eventType, version, err)
+			}
+
+			dec := json.NewDecoder(bytes.NewReader(env.Data))
+			dec.DisallowUnknownFields()
+			evt := eventTypes[eventType]()
+			if err := dec.Decode(evt); err != nil {
+				return fmt.Errorf("%s v%d: decode: %w", eventType, version, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckVersions(t *testing.T) {
+	for eventType := range eventTypes {
+		if _, ok := schemas[eventType]; !ok {
+			t.Errorf("%s has no registered schema", eventType)
+		}
+	}
+	if err := CheckVersions(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		wireType    string
+		wantBase    string
+		wantVersion int
+	}{
+		{"orders.created.v1", "orders.created", 1},
+		{"orders.created.v12", "orders.created", 12},
+		{"orders.created", "orders.created", 0},
+		{"orders.v2created", "orders.v2created", 0},
+		{"orders.created.v", "orders.created.v", 0},
+		{"orders.created.v0", "orders.created.v0", 0},
+		{"orders.created.v+2", "orders.created.v+2", 0},
+		{"orders.created.v-1", "orders.created.v-1", 0},
+		{"orders.created.v2x", "orders.created.v2x", 0},
+		{".v1", ".v1", 0},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wireType, func(t *testing.T) {
+			base, version := ParseType(tt.wireType)
+			if base != tt.wantBase || version != tt.wantVersion {
+				t.Errorf("ParseType(%q) = %q, %d, want %q, %d", tt.wireType, base, version, tt.wantBase, tt.wantVersion)
+			}
+		})
+	}
+}
+
+// registerTestSchema adds a three-version event whose upcasters append the
+// version they ran to a "steps" field.
+func registerTestSchema(t *testing.T, eventType string) {
+	t.Helper()
+	registerSchema(eventType, 3)
+	for from := 1; from < 3; from++ {
+		registerUpcaster(eventType, from, func(data json.RawMessage) (json.RawMessage, error) {
+			var v struct {
+				Steps []int `json:"steps"`
+			}
+			if err := json.Unmarshal(data, &v); err != nil {
+				return nil, err
+			}
+			v.Steps = append(v.Steps, from)
+			return json.Marshal(v)
+		})
+	}
+	t.Cleanup(func() { delete(schemas, eventType) })
+}
+
+func TestUpcast(t *testing.T) {
+	const eventType = "test.upcast"
+	registerTestSchema(t, eventType)
+
+	tests := []struct {
+		name      string
+		env       Envelope
+		wantSteps string
+		wantErr   error
+	}{
+		{name: "suffix v1", env: Envelope{Type: eventType + ".v1", Data: json.RawMessage(`{}`)}, wantSteps: "[1,2]"},
+		{name: "suffix v2", env: Envelope{Type: eventType + ".v2", Data: json.RawMessage(`{"steps":[1]}`)}, wantSteps: "[1,2]"},
+		{name: "latest", env: Envelope{Type: eventType + ".v3", Data: json.RawMessage(`{"steps":[1,2]}`)}, wantSteps: "[1,2]"},
+		{name: "schema_version without suffix", env: Envelope{Type: eventType, SchemaVersion: 2, Data: json.RawMessage(`{}`)}, wantSteps: "[2]"},
+		{name: "no version defaults to v1", env: Envelope{Type: eventType, Data: json.RawMessage(`{}`)}, wantSteps: "[1,2]"},
+		{name: "newer than known", env: Envelope{Type: eventType + ".v4", Data: json.RawMessage(`{}`)}, wantErr: ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Upcast(tt.env)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Upcast() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Upcast() error = %v", err)
+			}
+			if got.Type != eventType || got.SchemaVersion != 3 {
+				t.Errorf("Upcast() type = %q v%d, want %q v3", got.Type, got.SchemaVersion, eventType)
+			}
+			var v struct {
+				Steps json.RawMessage `json:"steps"`
+			}
+			if err := json.Unmarshal(got.Data, &v); err != nil {
+				t.Fatal(err)
+			}
+			if string(v.Steps) != tt.wantSteps {
+				t.Errorf("steps = %s, want %s", v.Steps, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func TestUpcastMissingUpcaster(t *testing.T) {
+	const eventType = "test.gap"
+	registerSchema(eventType, 2)
+	t.Cleanup(func() { delete(schemas, eventType) })
+
+	_, err := Upcast(Envelope{Type: eventType + ".v1", Data: json.RawMessage(`{}`)})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Upcast() error = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestUpcastUnknownTypePassesThrough(t *testing.T) {
+	got, err := Upcast(Envelope{Type: "unknown.event.v7", Data: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "unknown.event" || got.SchemaVersion != 7 {
+		t.Errorf("Upcast() = %q v%d, want unknown.event v7", got.Type, got.SchemaVersion)
+	}
+}
+
+func TestCheckVersionsCatchesMissingSample(t *testing.T) {
+	const eventType = EventOrderCancelled
+	saved := schemas[eventType]
+	t.Cleanup(func() { schemas[eventType] = saved })
+
+	registerSchema(eventType, 2)
+	registerUpcaster(eventType, 1, func(data json.RawMessage) (json.RawMessage, error) { return data, nil })
+	registerSample(eventType, 1, string(saved.samples[1]))
+
+	err := CheckVersions()
+	if err == nil || !strings.Contains(err.Error(), "no sample") {
+		t.Fatalf("CheckVersions() error = %v, want a missing sample error", err)
+	}
+}
+
+func TestNewEnvelopeUsesLatestVersion(t *testing.T) {
+	env, err := NewEnvelope(t.Context(), ProducerOrders, EventOrderCreated, "id-1", OrderCreatedEvent{EventID: "id-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != VersionedType(EventOrderCreated, LatestVersion(EventOrderCreated)) || env.SchemaVersion != LatestVersion(EventOrderCreated) {
+		t.Errorf("NewEnvelope() type = %q v%d", env.Type, env.SchemaVersion)
+	}
+	if env.CorrelationID != "id-1" || env.Producer != ProducerOrders {
+		t.Errorf("NewEnvelope() = %+v", env)
+	}
+}
@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	Header    = "Idempotency-Key"
+	maxKeyLen = 255
+)
+
+var (
+	ErrKeyReused  = errors.New("idempotency key reused with a different request")
+	ErrInvalidKey = errors.New("invalid Idempotency-Key header")
+)
+
+type Key struct {
+	UserID      string
+	Value       string
+	Fingerprint string
+}
+
+func FromRequest(r *http.Request, userID string, body []byte) (*Key, error) {
+	value := r.Header.Get(Header)
+	if value == "" {
+		return nil, nil
+	}
+	if len(value) > maxKeyLen {
+		return nil, ErrInvalidKey
+	}
+
+	return &Key{
+		UserID:      userID,
+		Value:       value,
+		Fingerprint: fingerprint(r.Method, r.URL.Path, body),
+	}, nil
+}
+
+// fingerprint hashes the request with its JSON body in canonical form, so
+// that a retry differing only in key order or whitespace still matches.
+// A body that is not JSON is hashed as is.
+func fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(canonicalJSON(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// canonicalJSON re-encodes body with sorted object keys and no
+// insignificant whitespace. Numbers keep their original text.
+func canonicalJSON(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return body
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return canonical
+}
+
+// Acquire claims the key inside tx. It returns the stored response when the
+// key has already been used for the same request, or nil when the caller owns
+// the key and must finish with Store before committing. A concurrent request
+// with the same key blocks on the unique index until the owner commits.
+func Acquire(ctx context.Context, tx pgx.Tx, table string, key Key) ([]byte, error) {
+	insert := fmt.Sprintf(`
+		INSERT INTO %s (user_id, key, fingerprint)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO NOTHING`, table)
+	tag, err := tx.Exec(ctx, insert, key.UserID, key.Value, key.Fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("insert idempotency key: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var (
+		fingerprint string
+		response    []byte
+	)
+	query := fmt.Sprintf(`
+		SELECT fingerprint, response
+		FROM %s
+		WHERE user_id = $1 AND key = $2`, table)
+	if err := tx.QueryRow(ctx, query, key.UserID, key.Value).Scan(&fingerprint, &response); err != nil {
+		return nil, fmt.Errorf("select idempotency key: %w", err)
+	}
+	if fingerprint != key.Fingerprint {
+		return nil, ErrKeyReused
+	}
+	if response == nil {
+		return nil, fmt.Errorf("idempotency key %q has no stored response", key.Value)
+	}
+	return response, nil
+}
+
+func Store(ctx context.Context, tx pgx.Tx, table string, key Key, response any) error {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("marshal idempotent response: %w", err)
+	}
+
+	update := fmt.Sprintf(`
+		UPDATE %s
+		SET response = $3
+		WHERE user_id = $1 AND key = $2`, table)
+	if _, err := tx.Exec(ctx, update, key.UserID, key.Value, payload); err != nil {
+		return fmt.Errorf("store idempotent response: %w", err)
+	}
+	return nil
+}
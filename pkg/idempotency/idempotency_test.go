@@ -0,0 +1,93 @@
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	base := fingerprint(http.MethodPost, "/orders", []byte(`{"items":[{"sku":"a","quantity":2}]}`))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		same   bool
+	}{
+		{name: "identical", method: http.MethodPost, path: "/orders", body: `{"items":[{"sku":"a","quantity":2}]}`, same: true},
+		{name: "key order", method: http.MethodPost, path: "/orders", body: `{"items":[{"quantity":2,"sku":"a"}]}`, same: true},
+		{name: "whitespace", method: http.MethodPost, path: "/orders", body: "{\n  \"items\": [ {\"sku\": \"a\", \"quantity\": 2} ]\n}\n", same: true},
+		{name: "different value", method: http.MethodPost, path: "/orders", body: `{"items":[{"sku":"a","quantity":3}]}`},
+		{name: "number text matters", method: http.MethodPost, path: "/orders", body: `{"items":[{"sku":"a","quantity":2.0}]}`},
+		{name: "array order matters", method: http.MethodPost, path: "/orders", body: `{"items":[{"sku":"a","quantity":2},{"sku":"b","quantity":1}]}`},
+		{name: "different path", method: http.MethodPost, path: "/accounts/deposit", body: `{"items":[{"sku":"a","quantity":2}]}`},
+		{name: "different method", method: http.MethodPut, path: "/orders", body: `{"items":[{"sku":"a","quantity":2}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fingerprint(tt.method, tt.path, []byte(tt.body))
+			if (got == base) != tt.same {
+				t.Errorf("fingerprint equal = %v, want %v", got == base, tt.same)
+			}
+		})
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "sorts keys", body: `{"b":1,"a":{"d":2,"c":3}}`, want: `{"a":{"c":3,"d":2},"b":1}`},
+		{name: "keeps large numbers", body: `{"amount": 9007199254740993}`, want: `{"amount":9007199254740993}`},
+		{name: "not json", body: `amount=5`, want: `amount=5`},
+		{name: "trailing data", body: `{"a":1} {"b":2}`, want: `{"a":1} {"b":2}`},
+		{name: "empty", body: ``, want: ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(canonicalJSON([]byte(tt.body))); got != tt.want {
+				t.Errorf("canonicalJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantNil bool
+		wantErr error
+	}{
+		{name: "no header", wantNil: true},
+		{name: "valid", key: "abc"},
+		{name: "too long", key: strings.Repeat("k", maxKeyLen+1), wantErr: ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+			if tt.key != "" {
+				r.Header.Set(Header, tt.key)
+			}
+			key, err := FromRequest(r, "user", []byte(`{}`))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if (key == nil) != tt.wantNil {
+				t.Fatalf("FromRequest() = %+v, want nil %v", key, tt.wantNil)
+			}
+			if key != nil && (key.UserID != "user" || key.Value != tt.key || key.Fingerprint == "") {
+				t.Errorf("FromRequest() = %+v", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package messaging
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// backoffDelay returns an exponentially growing delay for the given attempt
+// (starting at 1), capped at reconnectMaxDelay, with up to 50% jitter so that
+// several instances do not reconnect in lockstep.
+func backoffDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := reconnectMaxDelay
+	if attempt <= 16 {
+		delay = reconnectMinDelay << (attempt - 1)
+	}
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
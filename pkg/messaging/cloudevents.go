@@ -0,0 +1,176 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gozon/pkg/contracts"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Encoding selects how a publisher lays out events on the wire.
+type Encoding string
+
+const (
+	EncodingEnvelope              Encoding = "envelope"
+	EncodingCloudEventsBinary     Encoding = "cloudevents-binary"
+	EncodingCloudEventsStructured Encoding = "cloudevents-structured"
+)
+
+func ParseEncoding(raw string) (Encoding, error) {
+	switch e := Encoding(raw); e {
+	case EncodingEnvelope, EncodingCloudEventsBinary, EncodingCloudEventsStructured:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown event encoding %q", raw)
+}
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsContentType = "application/cloudevents+json"
+	cloudEventsPrefix      = "cloudEvents:"
+	cloudEventsSource      = "/gozon/"
+)
+
+// cloudEvent is the structured-mode JSON form. Envelope metadata without a
+// CloudEvents counterpart travels as extension attributes.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	CausationID     string          `json:"causationid,omitempty"`
+	SchemaVersion   int             `json:"schemaversion,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+func toCloudEvent(env contracts.Envelope) cloudEvent {
+	return cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              env.ID,
+		Source:          cloudEventsSource + env.Producer,
+		Type:            env.Type,
+		Time:            env.OccurredAt,
+		DataContentType: "application/json",
+		CorrelationID:   env.CorrelationID,
+		CausationID:     env.CausationID,
+		SchemaVersion:   env.SchemaVersion,
+		Data:            env.Data,
+	}
+}
+
+func (ce cloudEvent) envelope() contracts.Envelope {
+	return contracts.Envelope{
+		ID:            ce.ID,
+		Type:          ce.Type,
+		SchemaVersion: ce.SchemaVersion,
+		OccurredAt:    ce.Time,
+		CorrelationID: ce.CorrelationID,
+		CausationID:   ce.CausationID,
+		Producer:      strings.TrimPrefix(ce.Source, cloudEventsSource),
+		Data:          ce.Data,
+	}
+}
+
+func cloudEventsStructured(msg *amqp091.Publishing, env contracts.Envelope) error {
+	body, err := json.Marshal(toCloudEvent(env))
+	if err != nil {
+		return fmt.Errorf("marshal cloudevent: %w", err)
+	}
+	msg.ContentType = cloudEventsContentType
+	msg.Body = body
+	return nil
+}
+
+func cloudEventsBinary(msg *amqp091.Publishing, env contracts.Envelope) {
+	ce := toCloudEvent(env)
+	msg.Headers[cloudEventsPrefix+"specversion"] = ce.SpecVersion
+	msg.Headers[cloudEventsPrefix+"id"] = ce.ID
+	msg.Headers[cloudEventsPrefix+"source"] = ce.Source
+	msg.Headers[cloudEventsPrefix+"type"] = ce.Type
+	msg.Headers[cloudEventsPrefix+"time"] = ce.Time.UTC().Format(time.RFC3339Nano)
+	msg.Headers[cloudEventsPrefix+"schemaversion"] = int32(ce.SchemaVersion)
+	if ce.CorrelationID != "" {
+		msg.Headers[cloudEventsPrefix+"correlationid"] = ce.CorrelationID
+	}
+	if ce.CausationID != "" {
+		msg.Headers[cloudEventsPrefix+"causationid"] = ce.CausationID
+	}
+	msg.ContentType = ce.DataContentType
+	msg.Body = ce.Data
+}
+
+func isCloudEventsStructured(msg amqp091.Delivery) bool {
+	return strings.HasPrefix(msg.ContentType, cloudEventsContentType)
+}
+
+func isCloudEventsBinary(msg amqp091.Delivery) bool {
+	_, ok := msg.Headers[cloudEventsPrefix+"specversion"]
+	return ok
+}
+
+func decodeCloudEventsStructured(msg amqp091.Delivery) (contracts.Envelope, error) {
+	var ce cloudEvent
+	if err := json.Unmarshal(msg.Body, &ce); err != nil {
+		return contracts.Envelope{}, fmt.Errorf("decode cloudevent: %w", err)
+	}
+	if ce.SpecVersion != cloudEventsSpecVersion {
+		return contracts.Envelope{}, fmt.Errorf("unsupported cloudevents specversion %q", ce.SpecVersion)
+	}
+	if ce.ID == "" || ce.Type == "" {
+		return contracts.Envelope{}, fmt.Errorf("cloudevent is missing id or type")
+	}
+	if ce.SchemaVersion == 0 {
+		ce.SchemaVersion = contracts.DefaultSchemaVersion
+	}
+	return ce.envelope(), nil
+}
+
+func decodeCloudEventsBinary(msg amqp091.Delivery) (contracts.Envelope, error) {
+	attr := func(name string) string {
+		v, _ := msg.Headers[cloudEventsPrefix+name].(string)
+		return v
+	}
+
+	if v := attr("specversion"); v != cloudEventsSpecVersion {
+		return contracts.Envelope{}, fmt.Errorf("unsupported cloudevents specversion %q", v)
+	}
+	ce := cloudEvent{
+		SpecVersion:   cloudEventsSpecVersion,
+		ID:            attr("id"),
+		Source:        attr("source"),
+		Type:          attr("type"),
+		CorrelationID: attr("correlationid"),
+		CausationID:   attr("causationid"),
+		SchemaVersion: contracts.DefaultSchemaVersion,
+		Data:          msg.Body,
+	}
+	if ce.ID == "" || ce.Type == "" {
+		return contracts.Envelope{}, fmt.Errorf("cloudevent is missing id or type")
+	}
+	if raw := attr("time"); raw != "" {
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return contracts.Envelope{}, fmt.Errorf("invalid cloudevent time: %w", err)
+		}
+		ce.Time = t
+	}
+	switch v := msg.Headers[cloudEventsPrefix+"schemaversion"].(type) {
+	case int32:
+		ce.SchemaVersion = int(v)
+	case int64:
+		ce.SchemaVersion = int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			ce.SchemaVersion = n
+		}
+	}
+	return ce.envelope(), nil
+}
@@ -0,0 +1,161 @@
+package messaging
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestParseEncoding(t *testing.T) {
+	for _, raw := range []string{"envelope", "cloudevents-binary", "cloudevents-structured"} {
+		if got, err := ParseEncoding(raw); err != nil || string(got) != raw {
+			t.Errorf("ParseEncoding(%q) = %q, %v", raw, got, err)
+		}
+	}
+	for _, raw := range []string{"", "json", "CloudEvents-Binary"} {
+		if _, err := ParseEncoding(raw); err == nil {
+			t.Errorf("ParseEncoding(%q) accepted", raw)
+		}
+	}
+}
+
+func TestCloudEventsRoundTrip(t *testing.T) {
+	env := testEnvelope()
+	for _, encoding := range []Encoding{EncodingEnvelope, EncodingCloudEventsBinary, EncodingCloudEventsStructured} {
+		t.Run(string(encoding), func(t *testing.T) {
+			msg, err := envelopePublishing(env, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := EnvelopeFromDelivery(delivered(msg, env.Type))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEnvelope(t, got, env)
+		})
+	}
+}
+
+func TestCloudEventsBinaryLayout(t *testing.T) {
+	env := testEnvelope()
+	msg, err := envelopePublishing(env, EncodingCloudEventsBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"cloudEvents:specversion":   "1.0",
+		"cloudEvents:id":            env.ID,
+		"cloudEvents:source":        "/gozon/payments-service",
+		"cloudEvents:type":          env.Type,
+		"cloudEvents:time":          "2024-05-01T12:00:00.123Z",
+		"cloudEvents:schemaversion": int32(1),
+		"cloudEvents:correlationid": env.CorrelationID,
+		"cloudEvents:causationid":   env.CausationID,
+	}
+	for k, v := range want {
+		if msg.Headers[k] != v {
+			t.Errorf("header %s = %#v, want %#v", k, msg.Headers[k], v)
+		}
+	}
+	if msg.ContentType != "application/json" || string(msg.Body) != string(env.Data) {
+		t.Errorf("binary mode must carry the bare event: content type %q, body %s", msg.ContentType, msg.Body)
+	}
+}
+
+func TestCloudEventsStructuredLayout(t *testing.T) {
+	env := testEnvelope()
+	msg, err := envelopePublishing(env, EncodingCloudEventsStructured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentType != "application/cloudevents+json" {
+		t.Errorf("content type %q", msg.ContentType)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []string{"specversion", "id", "source", "type", "time", "datacontenttype", "data"} {
+		if _, ok := body[attr]; !ok {
+			t.Errorf("attribute %q missing from %s", attr, msg.Body)
+		}
+	}
+	if body["source"] != "/gozon/payments-service" {
+		t.Errorf("source = %v", body["source"])
+	}
+}
+
+func TestCloudEventsDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  amqp091.Delivery
+	}{
+		{
+			name: "structured wrong specversion",
+			msg:  amqp091.Delivery{ContentType: "application/cloudevents+json", Body: []byte(`{"specversion":"0.3","id":"e","type":"t","data":{}}`)},
+		},
+		{
+			name: "structured without id",
+			msg:  amqp091.Delivery{ContentType: "application/cloudevents+json", Body: []byte(`{"specversion":"1.0","type":"t","data":{}}`)},
+		},
+		{
+			name: "structured not json",
+			msg:  amqp091.Delivery{ContentType: "application/cloudevents+json; charset=utf-8", Body: []byte(`{`)},
+		},
+		{
+			name: "binary wrong specversion",
+			msg:  amqp091.Delivery{Headers: amqp091.Table{"cloudEvents:specversion": "0.3", "cloudEvents:id": "e", "cloudEvents:type": "t"}},
+		},
+		{
+			name: "binary without type",
+			msg:  amqp091.Delivery{Headers: amqp091.Table{"cloudEvents:specversion": "1.0", "cloudEvents:id": "e"}},
+		},
+		{
+			name: "binary bad time",
+			msg:  amqp091.Delivery{Headers: amqp091.Table{"cloudEvents:specversion": "1.0", "cloudEvents:id": "e", "cloudEvents:type": "t", "cloudEvents:time": "noon"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EnvelopeFromDelivery(tt.msg); err == nil {
+				t.Error("EnvelopeFromDelivery() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestCloudEventsDefaultSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  amqp091.Delivery
+		want int
+	}{
+		{
+			name: "structured without extension",
+			msg:  amqp091.Delivery{ContentType: "application/cloudevents+json", Body: []byte(`{"specversion":"1.0","id":"e","type":"t","data":{}}`)},
+			want: 1,
+		},
+		{
+			name: "binary without extension",
+			msg:  amqp091.Delivery{Headers: amqp091.Table{"cloudEvents:specversion": "1.0", "cloudEvents:id": "e", "cloudEvents:type": "t"}, Body: []byte(`{}`)},
+			want: 1,
+		},
+		{
+			name: "binary string extension",
+			msg:  amqp091.Delivery{Headers: amqp091.Table{"cloudEvents:specversion": "1.0", "cloudEvents:id": "e", "cloudEvents:type": "t", "cloudEvents:schemaversion": "2"}, Body: []byte(`{}`)},
+			want: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := EnvelopeFromDelivery(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.SchemaVersion != tt.want {
+				t.Errorf("SchemaVersion = %d, want %d", env.SchemaVersion, tt.want)
+			}
+		})
+	}
+}
@@ -2,46 +2,201 @@ package messaging
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gozon/pkg/contracts"
+	"gozon/pkg/tracing"
 
 	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type Consumer struct {
-	conn   *amqp091.Connection
-	queue  string
-	logger *slog.Logger
+type ConsumerState string
+
+const (
+	ConsumerConnecting   ConsumerState = "connecting"
+	ConsumerConsuming    ConsumerState = "consuming"
+	ConsumerReconnecting ConsumerState = "reconnecting"
+	ConsumerStopped      ConsumerState = "stopped"
+)
+
+type ConsumerStatus struct {
+	Queue             string        `json:"queue"`
+	State             ConsumerState `json:"state"`
+	ReconnectAttempts int64         `json:"reconnect_attempts"`
+	LastError         string        `json:"last_error,omitempty"`
+}
+
+// Handler processes one event. A nil error acks the message; any other
+// error schedules a delayed retry, dead-letters the message once the retry
+// policy is exhausted, or parks it when the error is Permanent.
+type Handler func(context.Context, contracts.Envelope) error
+
+// ConsumerOptions tunes how a Consumer binds its queue and processes
+// deliveries. Bindings are routing-key patterns; fanout exchanges ignore
+// them, so they may be empty there. Workers handle deliveries in parallel,
+// except that deliveries with the same Key are handled in order.
+type ConsumerOptions struct {
+	Bindings []string
+	Retry    RetryPolicy
+	Workers  int
+	Key      KeyFunc
+}
+
+const minPrefetch = 32
+
+// amqpConn and amqpChannel are the parts of the amqp091 connection and
+// channel the consumer uses, so that tests can stand in for the broker.
+type amqpConn interface {
+	Channel() (amqpChannel, error)
+	NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error
+	IsClosed() bool
+	Close() error
 }
 
-func NewRabbitConsumer(url, exchange, queue string, logger *slog.Logger) (*Consumer, error) {
+type amqpChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Confirm(noWait bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) (*amqp091.DeferredConfirmation, error)
+	Close() error
+}
+
+type rabbitConn struct {
+	*amqp091.Connection
+}
+
+func (c rabbitConn) Channel() (amqpChannel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func dialRabbit(url string) (amqpConn, error) {
 	conn, err := amqp091.Dial(url)
 	if err != nil {
-		return nil, fmt.Errorf("connect rabbitmq: %w", err)
+		return nil, err
+	}
+	return rabbitConn{conn}, nil
+}
+
+type Consumer struct {
+	url      string
+	exchange string
+	kind     string
+	queue    string
+	tag      string
+	opts     ConsumerOptions
+	logger   *slog.Logger
+	dial     func(url string) (amqpConn, error)
+
+	mu        sync.Mutex
+	conn      amqpConn
+	state     ConsumerState
+	lastError string
+	attempts  atomic.Int64
+
+	running   sync.WaitGroup
+	done      chan struct{}
+	closeOnce sync.Once
+	// abort is closed when the context given to Close expires, cutting
+	// the drain short.
+	abort     chan struct{}
+	abortOnce sync.Once
+}
+
+func NewRabbitConsumer(url, exchange, kind, queue string, opts ConsumerOptions, logger *slog.Logger) (*Consumer, error) {
+	c, err := newConsumer(url, exchange, kind, queue, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newConsumer validates the options and builds a consumer that has not
+// connected yet.
+func newConsumer(url, exchange, kind, queue string, opts ConsumerOptions, logger *slog.Logger) (*Consumer, error) {
+	if err := validateExchangeKind(kind); err != nil {
+		return nil, err
+	}
+	if len(opts.Bindings) == 0 {
+		if kind != amqp091.ExchangeFanout {
+			return nil, fmt.Errorf("%s exchange %q needs at least one binding", kind, exchange)
+		}
+		opts.Bindings = []string{""}
+	}
+	if opts.Retry.MaxAttempts < 1 {
+		opts.Retry.MaxAttempts = 1
+	}
+	if len(opts.Retry.Delays) == 0 {
+		opts.Retry.Delays = defaultRetryDelays
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Key == nil {
+		opts.Key = func(amqp091.Delivery) string { return "" }
+	}
+	return &Consumer{
+		url:      url,
+		exchange: exchange,
+		kind:     kind,
+		queue:    queue,
+		tag:      queue + "-" + newMessageID()[:8],
+		opts:     opts,
+		logger:   logger,
+		dial:     dialRabbit,
+		state:    ConsumerConnecting,
+		done:     make(chan struct{}),
+		abort:    make(chan struct{}),
+	}, nil
+}
+
+func (c *Consumer) connect() error {
+	conn, err := c.dial(c.url)
+	if err != nil {
+		return fmt.Errorf("connect rabbitmq: %w", err)
 	}
 
 	ch, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("open channel: %w", err)
+		return fmt.Errorf("open channel: %w", err)
 	}
 	defer ch.Close()
 
-	if err := ch.ExchangeDeclare(
-		exchange,
-		"fanout",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	); err != nil {
+	if err := declareExchange(ch, c.exchange, c.kind); err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("declare exchange: %w", err)
+		return err
 	}
 
+	if err := declareRetryTopology(ch, c.queue, c.opts.Retry); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// The queue keeps the arguments it has always been declared with:
+	// redeclaring an existing queue with different ones fails. The consumer
+	// publishes to the dead-letter exchange itself, so the queue does not
+	// need x-dead-letter-exchange.
 	if _, err := ch.QueueDeclare(
-		queue,
+		c.queue,
 		true,
 		false,
 		false,
@@ -49,66 +204,301 @@ func NewRabbitConsumer(url, exchange, queue string, logger *slog.Logger) (*Consu
 		nil,
 	); err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("declare queue: %w", err)
+		return fmt.Errorf("declare queue: %w", err)
 	}
 
-	if err := ch.QueueBind(
-		queue,
-		"",
-		exchange,
-		false,
-		nil,
-	); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("bind queue: %w", err)
+	for _, key := range c.opts.Bindings {
+		if err := ch.QueueBind(
+			c.queue,
+			key,
+			c.exchange,
+			false,
+			nil,
+		); err != nil {
+			conn.Close()
+			return fmt.Errorf("bind queue to %q: %w", key, err)
+		}
 	}
 
-	return &Consumer{
-		conn:   conn,
-		queue:  queue,
-		logger: logger,
-	}, nil
+	// A reconnect after a channel-level error finds the old connection
+	// still open; close it so that each reconnect does not leak one.
+	c.mu.Lock()
+	if c.conn != nil && !c.conn.IsClosed() {
+		_ = c.conn.Close()
+	}
+	c.conn = conn
+	c.mu.Unlock()
+	return nil
+}
+
+// Start consumes until ctx is cancelled or Close is called. When the
+// connection or channel drops it reconnects with jittered backoff,
+// re-declares the topology and resumes consuming.
+func (c *Consumer) Start(ctx context.Context, handler Handler) error {
+	c.running.Add(1)
+	defer c.running.Done()
+	defer c.setState(ConsumerStopped, nil)
+
+	for {
+		err := c.consume(ctx, handler)
+		if ctx.Err() != nil || c.closed() {
+			return nil
+		}
+
+		c.setState(ConsumerReconnecting, err)
+		if c.logger != nil {
+			c.logger.Warn("consumer interrupted", "queue", c.queue, "err", err)
+		}
+
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-c.done:
+				return nil
+			case <-time.After(backoffDelay(attempt)):
+			}
+
+			c.attempts.Add(1)
+			if err := c.connect(); err != nil {
+				c.setState(ConsumerReconnecting, err)
+				if c.logger != nil {
+					c.logger.Warn("consumer reconnect failed", "queue", c.queue, "attempt", attempt, "err", err)
+				}
+				continue
+			}
+			if c.logger != nil {
+				c.logger.Info("consumer reconnected", "queue", c.queue, "attempt", attempt)
+			}
+			break
+		}
+	}
 }
 
-func (c *Consumer) Start(ctx context.Context, handler func(context.Context, amqp091.Delivery)) error {
-	ch, err := c.conn.Channel()
+func (c *Consumer) consume(ctx context.Context, handler Handler) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil || conn.IsClosed() {
+		return errors.New("connection closed")
+	}
+
+	ch, err := conn.Channel()
 	if err != nil {
 		return fmt.Errorf("open channel: %w", err)
 	}
+	defer ch.Close()
 
-	if err := ch.Qos(32, 0, false); err != nil {
-		ch.Close()
+	if err := ch.Qos(max(minPrefetch, 2*c.opts.Workers), 0, false); err != nil {
 		return fmt.Errorf("set qos: %w", err)
 	}
 
-	msgs, err := ch.Consume(c.queue, "", false, false, false, false, nil)
+	pubCh, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("open retry channel: %w", err)
+	}
+	defer pubCh.Close()
+
+	if err := pubCh.Confirm(false); err != nil {
+		return fmt.Errorf("enable confirms: %w", err)
+	}
+
+	connClosed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp091.Error, 1))
+
+	msgs, err := ch.Consume(c.queue, c.tag, false, false, false, false, nil)
 	if err != nil {
-		ch.Close()
 		return fmt.Errorf("consume queue: %w", err)
 	}
 
-	go func() {
-		<-ctx.Done()
-		_ = ch.Cancel("", false)
-		ch.Close()
-	}()
+	// Handlers run on a context that outlives ctx so that work already
+	// handed to the pool can finish and be acked during shutdown.
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	pool := newWorkerPool(c.opts.Workers, max(minPrefetch, 2*c.opts.Workers), func(msg amqp091.Delivery) {
+		c.handle(workCtx, pubCh, msg, handler)
+	})
+
+	c.setState(ConsumerConsuming, nil)
 
 	for {
 		select {
 		case <-ctx.Done():
+			_ = ch.Cancel(c.tag, false)
+			c.drain(pool, cancelWork)
 			return nil
+		case <-c.done:
+			_ = ch.Cancel(c.tag, false)
+			c.drain(pool, cancelWork)
+			return nil
+		case amqpErr := <-connClosed:
+			c.abandon(pool, cancelWork)
+			return fmt.Errorf("connection closed: %v", amqpErr)
+		case amqpErr := <-chClosed:
+			c.abandon(pool, cancelWork)
+			return fmt.Errorf("channel closed: %v", amqpErr)
 		case msg, ok := <-msgs:
 			if !ok {
-				if c.logger != nil {
-					c.logger.Info("consumer channel closed")
-				}
-				return nil
+				c.abandon(pool, cancelWork)
+				return errors.New("delivery channel closed")
 			}
-			handler(ctx, msg)
+			pool.submit(c.opts.Key(msg), msg)
+		}
+	}
+}
+
+// drain lets the workers finish what they already hold, giving up when
+// the context passed to Close expires; anything left unacked is redelivered
+// by the broker.
+func (c *Consumer) drain(pool *workerPool, cancelWork context.CancelFunc) {
+	pool.close()
+	select {
+	case <-pool.wait():
+	case <-c.abort:
+		if c.logger != nil {
+			c.logger.Warn("consumer drain timed out", "queue", c.queue)
 		}
+		cancelWork()
+		<-pool.wait()
+	}
+}
+
+// abandon stops the workers after the channel is gone; their deliveries
+// can no longer be acked and will be redelivered.
+func (c *Consumer) abandon(pool *workerPool, cancelWork context.CancelFunc) {
+	cancelWork()
+	pool.close()
+	<-pool.wait()
+}
+
+func (c *Consumer) handle(ctx context.Context, pubCh amqpChannel, msg amqp091.Delivery, handler Handler) {
+	restoreRoutingKey(&msg)
+
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Headers))
+	ctx, span := tracer.Start(ctx, c.queue+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messageAttributes(msg.Exchange, msg.RoutingKey, msg.MessageId)...),
+	)
+	defer span.End()
+
+	env, err := EnvelopeFromDelivery(msg)
+	if err == nil {
+		env, err = contracts.Upcast(env)
+	}
+	if err == nil {
+		err = handler(contracts.WithCause(ctx, env), env)
+	} else {
+		err = Permanent(err)
+	}
+	tracing.Fail(span, err)
+	if err == nil {
+		_ = msg.Ack(false)
+		consumerMessages.WithLabelValues(c.queue, outcomeAck).Inc()
+		return
+	}
+	if ctx.Err() != nil {
+		_ = msg.Nack(false, true)
+		consumerMessages.WithLabelValues(c.queue, outcomeRequeue).Inc()
+		return
+	}
+
+	attempt := attempts(msg) + 1
+	retry := republishing(msg, attempt, err)
+
+	var exchange, key, outcome string
+	switch {
+	case IsPermanent(err):
+		key, outcome = ParkingQueue(c.queue), outcomeParked
+		c.log(slog.LevelError, "message parked", msg, attempt, err)
+	case attempt >= c.opts.Retry.MaxAttempts:
+		exchange, outcome = DeadLetterExchange(c.queue), outcomeDeadLetter
+		c.log(slog.LevelError, "message dead-lettered", msg, attempt, err)
+	default:
+		key, outcome = retryQueue(c.queue, c.opts.Retry.delay(attempt)), outcomeRetry
+		c.log(slog.LevelWarn, "message scheduled for retry", msg, attempt, err)
 	}
+
+	if pubErr := publishConfirmed(ctx, pubCh, exchange, key, retry); pubErr != nil {
+		c.log(slog.LevelError, "republish failed, requeueing", msg, attempt, pubErr)
+		_ = msg.Nack(false, true)
+		consumerMessages.WithLabelValues(c.queue, outcomeRequeue).Inc()
+		return
+	}
+	_ = msg.Ack(false)
+	consumerMessages.WithLabelValues(c.queue, outcome).Inc()
 }
 
-func (c *Consumer) Close() error {
-	return c.conn.Close()
+func (c *Consumer) log(level slog.Level, text string, msg amqp091.Delivery, attempt int, err error) {
+	if c.logger == nil {
+		return
+	}
+	c.logger.Log(context.Background(), level, text,
+		"queue", c.queue,
+		"routing_key", msg.RoutingKey,
+		"message_id", msg.MessageId,
+		"attempt", attempt,
+		"err", err,
+	)
+}
+
+func (c *Consumer) setState(state ConsumerState, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	if err != nil {
+		c.lastError = err.Error()
+	}
+}
+
+func (c *Consumer) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Consumer) Status() ConsumerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConsumerStatus{
+		Queue:             c.queue,
+		State:             c.state,
+		ReconnectAttempts: c.attempts.Load(),
+		LastError:         c.lastError,
+	}
+}
+
+func (c *Consumer) Healthy() bool {
+	return c.Status().State == ConsumerConsuming
+}
+
+// Close stops consuming, waits for Start to drain in-flight deliveries and
+// then closes the connection. When ctx expires first, the handlers still
+// running are cancelled and their deliveries left for redelivery.
+func (c *Consumer) Close(ctx context.Context) error {
+	c.closeOnce.Do(func() { close(c.done) })
+
+	stopped := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		c.abortOnce.Do(func() { close(c.abort) })
+		<-stopped
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil || conn.IsClosed() {
+		return nil
+	}
+	return conn.Close()
 }
@@ -0,0 +1,521 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gozon/pkg/contracts"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// fakeBroker hands out fake connections and records what is declared on
+// them. existing lists exchanges, by name and kind, that were declared
+// before the test started.
+type fakeBroker struct {
+	mu       sync.Mutex
+	conns    []*fakeConn
+	existing map[string]string
+}
+
+func (b *fakeBroker) dial(string) (amqpConn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	conn := &fakeConn{broker: b}
+	b.conns = append(b.conns, conn)
+	return conn, nil
+}
+
+func (b *fakeBroker) conn(i int) *fakeConn {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i >= len(b.conns) {
+		return nil
+	}
+	return b.conns[i]
+}
+
+type fakeConn struct {
+	broker *fakeBroker
+
+	closed    bool
+	notify    []chan *amqp091.Error
+	channels  []*fakeChannel
+	exchanges map[string]string
+	queues    map[string]amqp091.Table
+	bindings  []fakeBinding
+}
+
+type fakeBinding struct {
+	queue, key, exchange string
+}
+
+func (c *fakeConn) Channel() (amqpChannel, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		return nil, amqp091.ErrClosed
+	}
+	ch := &fakeChannel{conn: c, deliveries: make(chan amqp091.Delivery)}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakeConn) NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *fakeConn) IsClosed() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConn) Close() error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// snapshot returns what was declared on the connection and which of its
+// channels are closed.
+func (c *fakeConn) snapshot() (exchanges map[string]string, queues map[string]amqp091.Table, bindings []fakeBinding, closed bool, openChannels int) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	for _, ch := range c.channels {
+		if !ch.closed {
+			openChannels++
+		}
+	}
+	return c.exchanges, c.queues, c.bindings, c.closed, openChannels
+}
+
+// route returns the queues a message published to exchange with key would
+// reach, matching bindings the way RabbitMQ does for the exchange's kind.
+func (c *fakeConn) route(exchange, key string) []string {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	var queues []string
+	for _, b := range c.bindings {
+		if b.exchange != exchange || slices.Contains(queues, b.queue) {
+			continue
+		}
+		var match bool
+		switch c.exchanges[exchange] {
+		case amqp091.ExchangeFanout:
+			match = true
+		case amqp091.ExchangeDirect:
+			match = b.key == key
+		case amqp091.ExchangeTopic:
+			match = topicMatch(strings.Split(b.key, "."), strings.Split(key, "."))
+		}
+		if match {
+			queues = append(queues, b.queue)
+		}
+	}
+	return queues
+}
+
+// topicMatch reports whether a routing key matches a binding pattern, where
+// * stands for one word and # for zero or more.
+func topicMatch(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if topicMatch(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && topicMatch(pattern[1:], key[1:])
+	}
+	return len(key) > 0 && pattern[0] == key[0] && topicMatch(pattern[1:], key[1:])
+}
+
+type fakeChannel struct {
+	conn       *fakeConn
+	closed     bool
+	notify     []chan *amqp091.Error
+	deliveries chan amqp091.Delivery
+}
+
+func (ch *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error {
+	ch.conn.broker.mu.Lock()
+	defer ch.conn.broker.mu.Unlock()
+	if have, ok := ch.conn.broker.existing[name]; ok && have != kind {
+		return &amqp091.Error{
+			Code:   amqp091.PreconditionFailed,
+			Reason: "PRECONDITION_FAILED - inequivalent arg 'type' for exchange '" + name + "'",
+		}
+	}
+	if ch.conn.exchanges == nil {
+		ch.conn.exchanges = map[string]string{}
+	}
+	ch.conn.exchanges[name] = kind
+	return nil
+}
+
+func (ch *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error) {
+	ch.conn.broker.mu.Lock()
+	defer ch.conn.broker.mu.Unlock()
+	if ch.conn.queues == nil {
+		ch.conn.queues = map[string]amqp091.Table{}
+	}
+	ch.conn.queues[name] = args
+	return amqp091.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error {
+	ch.conn.broker.mu.Lock()
+	defer ch.conn.broker.mu.Unlock()
+	ch.conn.bindings = append(ch.conn.bindings, fakeBinding{queue: name, key: key, exchange: exchange})
+	return nil
+}
+
+func (ch *fakeChannel) Qos(int, int, bool) error { return nil }
+func (ch *fakeChannel) Confirm(bool) error       { return nil }
+
+func (ch *fakeChannel) Consume(string, string, bool, bool, bool, bool, amqp091.Table) (<-chan amqp091.Delivery, error) {
+	return ch.deliveries, nil
+}
+
+func (ch *fakeChannel) Cancel(string, bool) error { return nil }
+
+func (ch *fakeChannel) NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error {
+	ch.conn.broker.mu.Lock()
+	defer ch.conn.broker.mu.Unlock()
+	ch.notify = append(ch.notify, receiver)
+	return receiver
+}
+
+func (ch *fakeChannel) PublishWithDeferredConfirmWithContext(context.Context, string, string, bool, bool, amqp091.Publishing) (*amqp091.DeferredConfirmation, error) {
+	return nil, errors.New("fake channel does not publish")
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.conn.broker.mu.Lock()
+	defer ch.conn.broker.mu.Unlock()
+	ch.closed = true
+	return nil
+}
+
+// fail reports a channel-level error to whoever watches the channel, the
+// way the broker does when it closes a channel but not the connection.
+func (ch *fakeChannel) fail() {
+	ch.conn.broker.mu.Lock()
+	receivers := ch.notify
+	ch.conn.broker.mu.Unlock()
+	for _, r := range receivers {
+		r <- &amqp091.Error{Code: amqp091.PreconditionFailed, Reason: "test"}
+	}
+}
+
+func newTestConsumer(t *testing.T, broker *fakeBroker, kind string, opts ConsumerOptions) *Consumer {
+	t.Helper()
+	c, err := newConsumer("amqp://test", "orders.events", kind, "payments.orders", opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.dial = broker.dial
+	if err := c.connect(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConsumerReconnectReplacesConnection(t *testing.T) {
+	broker := &fakeBroker{}
+	c := newTestConsumer(t, broker, amqp091.ExchangeTopic, ConsumerOptions{Bindings: []string{"orders.created.#", "orders.cancelled.#"}})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Start(context.Background(), func(context.Context, contracts.Envelope) error { return nil })
+	}()
+
+	first := broker.conn(0)
+	// connect uses a channel for the topology; consume opens two more.
+	waitFor(t, "the first consumer channels", func() bool {
+		_, _, _, _, open := first.snapshot()
+		return c.Healthy() && open == 2
+	})
+
+	// A channel-level error leaves the connection open.
+	first.broker.mu.Lock()
+	consumeCh := first.channels[1]
+	first.broker.mu.Unlock()
+	consumeCh.fail()
+
+	waitFor(t, "the reconnect", func() bool {
+		return broker.conn(1) != nil && c.Healthy()
+	})
+
+	if _, _, _, closed, open := first.snapshot(); !closed || open != 0 {
+		t.Errorf("old connection closed = %v with %d open channels, want it closed with none", closed, open)
+	}
+
+	exchanges, queues, bindings, _, _ := broker.conn(1).snapshot()
+	if exchanges["orders.events"] != amqp091.ExchangeTopic {
+		t.Errorf("exchanges after reconnect = %v, want orders.events re-declared", exchanges)
+	}
+	for _, q := range []string{"payments.orders", DeadLetterQueue("payments.orders"), ParkingQueue("payments.orders")} {
+		if _, ok := queues[q]; !ok {
+			t.Errorf("queue %s not re-declared, have %v", q, queues)
+		}
+	}
+	want := map[string]bool{"orders.created.#": true, "orders.cancelled.#": true}
+	for _, b := range bindings {
+		if b.queue == "payments.orders" && b.exchange == "orders.events" {
+			delete(want, b.key)
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("bindings after reconnect = %v, missing %v", bindings, want)
+	}
+	if got := c.Status().ReconnectAttempts; got != 1 {
+		t.Errorf("reconnect attempts = %d, want 1", got)
+	}
+
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("Start() = %v", err)
+	}
+	if _, _, _, closed, open := broker.conn(1).snapshot(); !closed || open != 0 {
+		t.Errorf("after Close the connection closed = %v with %d open channels", closed, open)
+	}
+}
+
+func TestConsumerDeclaresQueueWithoutArguments(t *testing.T) {
+	broker := &fakeBroker{}
+	newTestConsumer(t, broker, amqp091.ExchangeTopic, ConsumerOptions{Bindings: []string{"#"}})
+
+	// A queue declared before dead-lettering existed has no arguments, and
+	// redeclaring it with any would fail with PRECONDITION_FAILED.
+	_, queues, _, _, _ := broker.conn(0).snapshot()
+	args, ok := queues["payments.orders"]
+	if !ok {
+		t.Fatalf("main queue not declared, have %v", queues)
+	}
+	if len(args) != 0 {
+		t.Errorf("main queue declared with %v, want no arguments", args)
+	}
+	if _, ok := queues[DeadLetterQueue("payments.orders")]; !ok {
+		t.Errorf("dead-letter queue not declared, have %v", queues)
+	}
+}
+
+func TestConsumerBindingRouting(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		bindings []string
+		routed   []string
+		dropped  []string
+	}{
+		{
+			name:     "topic",
+			kind:     amqp091.ExchangeTopic,
+			bindings: []string{"orders.created.#", "orders.cancelled.#"},
+			routed:   []string{"orders.created.v1", "orders.cancelled.v2", "orders.created"},
+			dropped:  []string{"orders.completed.v1", "orders.createdx.v1", "payments.processed.v1"},
+		},
+		{
+			name:     "topic single word",
+			kind:     amqp091.ExchangeTopic,
+			bindings: []string{"orders.*.v1"},
+			routed:   []string{"orders.created.v1", "orders.completed.v1"},
+			dropped:  []string{"orders.created.v2", "orders.created"},
+		},
+		{
+			name:     "direct",
+			kind:     amqp091.ExchangeDirect,
+			bindings: []string{"orders.created.v1"},
+			routed:   []string{"orders.created.v1"},
+			dropped:  []string{"orders.created.v2", "orders.created"},
+		},
+		{
+			name:   "fanout without bindings",
+			kind:   amqp091.ExchangeFanout,
+			routed: []string{"orders.created.v1", "anything"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			newTestConsumer(t, broker, tt.kind, ConsumerOptions{Bindings: tt.bindings})
+			conn := broker.conn(0)
+
+			if exchanges, _, _, _, _ := conn.snapshot(); exchanges["orders.events"] != tt.kind {
+				t.Errorf("exchange declared as %q, want %q", exchanges["orders.events"], tt.kind)
+			}
+			for _, key := range tt.routed {
+				if got := conn.route("orders.events", key); !slices.Equal(got, []string{"payments.orders"}) {
+					t.Errorf("%s routed to %v, want payments.orders", key, got)
+				}
+			}
+			for _, key := range tt.dropped {
+				if got := conn.route("orders.events", key); len(got) != 0 {
+					t.Errorf("%s routed to %v, want nowhere", key, got)
+				}
+			}
+		})
+	}
+}
+
+func TestConsumerRequiresBindings(t *testing.T) {
+	for _, kind := range []string{amqp091.ExchangeTopic, amqp091.ExchangeDirect} {
+		if _, err := newConsumer("amqp://test", "orders.events", kind, "payments.orders", ConsumerOptions{}, nil); err == nil {
+			t.Errorf("%s consumer without bindings was accepted", kind)
+		}
+	}
+	if _, err := newConsumer("amqp://test", "orders.events", "x-unknown", "payments.orders", ConsumerOptions{Bindings: []string{"#"}}, nil); err == nil {
+		t.Error("unknown exchange kind was accepted")
+	}
+}
+
+func TestConsumerExchangeKindMismatch(t *testing.T) {
+	// The exchange is left over from when everything was fanout.
+	broker := &fakeBroker{existing: map[string]string{"orders.events": amqp091.ExchangeFanout}}
+	c, err := newConsumer("amqp://test", "orders.events", amqp091.ExchangeTopic, "payments.orders", ConsumerOptions{Bindings: []string{"#"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.dial = broker.dial
+
+	err = c.connect()
+	if !errors.Is(err, ErrExchangeKindMismatch) {
+		t.Fatalf("connect() error = %v, want ErrExchangeKindMismatch", err)
+	}
+	for _, want := range []string{`"orders.events"`, "topic", "delete the exchange"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if _, _, _, closed, _ := broker.conn(0).snapshot(); !closed {
+		t.Error("connection left open after the failed declare")
+	}
+
+	// Declaring it with its existing kind still works.
+	newTestConsumer(t, broker, amqp091.ExchangeFanout, ConsumerOptions{})
+	if got := broker.conn(1).route("orders.events", "orders.created.v1"); len(got) != 1 {
+		t.Errorf("fanout consumer routed to %v", got)
+	}
+}
+
+// fakeAcknowledger records how deliveries were settled.
+type fakeAcknowledger struct {
+	mu     sync.Mutex
+	acks   int
+	nacks  int
+	settle chan struct{}
+}
+
+func (a *fakeAcknowledger) Ack(uint64, bool) error { return a.record(&a.acks) }
+
+func (a *fakeAcknowledger) Nack(uint64, bool, bool) error { return a.record(&a.nacks) }
+
+func (a *fakeAcknowledger) Reject(uint64, bool) error { return a.record(&a.nacks) }
+
+func (a *fakeAcknowledger) record(n *int) error {
+	a.mu.Lock()
+	*n++
+	a.mu.Unlock()
+	a.settle <- struct{}{}
+	return nil
+}
+
+// deliver hands one event to the consumer over its consume channel.
+func deliver(t *testing.T, broker *fakeBroker, ack amqp091.Acknowledger) {
+	t.Helper()
+	conn := broker.conn(0)
+	waitFor(t, "the consume channel", func() bool {
+		_, _, _, _, open := conn.snapshot()
+		return open == 2
+	})
+	conn.broker.mu.Lock()
+	consumeCh := conn.channels[1]
+	conn.broker.mu.Unlock()
+
+	body, err := json.Marshal(contracts.Envelope{ID: "evt-1", Type: "orders.created.v1", SchemaVersion: 1, Data: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumeCh.deliveries <- amqp091.Delivery{Acknowledger: ack, RoutingKey: "orders.created.v1", Body: body}
+}
+
+func TestConsumerCloseDrainsWithinContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		work      time.Duration
+		grace     time.Duration
+		wantAcks  int
+		wantNacks int
+	}{
+		{name: "handler finishes in time", work: 50 * time.Millisecond, grace: 5 * time.Second, wantAcks: 1},
+		{name: "grace period runs out", work: time.Hour, grace: 100 * time.Millisecond, wantNacks: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &fakeBroker{}
+			c := newTestConsumer(t, broker, amqp091.ExchangeTopic, ConsumerOptions{Bindings: []string{"#"}})
+
+			started := make(chan struct{})
+			go c.Start(context.Background(), func(ctx context.Context, env contracts.Envelope) error {
+				close(started)
+				select {
+				case <-time.After(tt.work):
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+
+			ack := &fakeAcknowledger{settle: make(chan struct{}, 1)}
+			deliver(t, broker, ack)
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.grace)
+			defer cancel()
+			begin := time.Now()
+			if err := c.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if took := time.Since(begin); took > tt.grace+time.Second {
+				t.Errorf("Close took %s with a %s grace period", took, tt.grace)
+			}
+
+			<-ack.settle
+			ack.mu.Lock()
+			defer ack.mu.Unlock()
+			if ack.acks != tt.wantAcks || ack.nacks != tt.wantNacks {
+				t.Errorf("acks %d, nacks %d, want %d and %d", ack.acks, ack.nacks, tt.wantAcks, tt.wantNacks)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package messaging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gozon/pkg/contracts"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var ErrUnknownSource = errors.New("unknown dead-letter source")
+
+const (
+	SourceDeadLetter = "dlq"
+	SourceParking    = "parking"
+)
+
+const (
+	ActionReplay  = "replay"
+	ActionDiscard = "discard"
+)
+
+const (
+	ResultReplayed  = "replayed"
+	ResultDiscarded = "discarded"
+	ResultNotFound  = "not_found"
+	ResultFailed    = "failed"
+)
+
+// Decoder turns a message body into a typed payload for display.
+type Decoder func(eventType string, body []byte) (any, error)
+
+type DeadLetter struct {
+	MessageID   string         `json:"message_id"`
+	Source      string         `json:"source"`
+	Exchange    string         `json:"exchange"`
+	RoutingKey  string         `json:"routing_key"`
+	Attempts    int            `json:"attempts"`
+	LastError   string         `json:"last_error,omitempty"`
+	Headers     map[string]any `json:"headers"`
+	Payload     any            `json:"payload,omitempty"`
+	DecodeError string         `json:"decode_error,omitempty"`
+	Body        string         `json:"body"`
+}
+
+type DeadLetterList struct {
+	Queue    string       `json:"queue"`
+	Source   string       `json:"source"`
+	Total    int          `json:"total"`
+	Messages []DeadLetter `json:"messages"`
+}
+
+type ActionResult struct {
+	MessageID string `json:"message_id"`
+	Result    string `json:"result"`
+	Error     string `json:"error,omitempty"`
+}
+
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Queue      string    `json:"queue"`
+	Source     string    `json:"source"`
+	MessageID  string    `json:"message_id"`
+	RoutingKey string    `json:"routing_key"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DeadLetterAdmin inspects, replays and discards messages parked in a
+// consumer queue's dead-letter and parking queues. Messages are read with
+// basic.get and returned to the queue unless they are acted upon, so the
+// queue itself stays the source of truth.
+type DeadLetterAdmin struct {
+	url        string
+	queue      string
+	pool       *pgxpool.Pool
+	auditTable string
+	decode     Decoder
+	logger     *slog.Logger
+
+	mu sync.Mutex
+}
+
+func NewDeadLetterAdmin(url, queue string, pool *pgxpool.Pool, auditTable string, decode Decoder, logger *slog.Logger) *DeadLetterAdmin {
+	return &DeadLetterAdmin{
+		url:        url,
+		queue:      queue,
+		pool:       pool,
+		auditTable: auditTable,
+		decode:     decode,
+		logger:     logger,
+	}
+}
+
+func (a *DeadLetterAdmin) sourceQueue(source string) (string, error) {
+	switch source {
+	case SourceDeadLetter:
+		return DeadLetterQueue(a.queue), nil
+	case SourceParking:
+		return ParkingQueue(a.queue), nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownSource, source)
+}
+
+func (a *DeadLetterAdmin) List(ctx context.Context, source string, limit int) (*DeadLetterList, error) {
+	name, err := a.sourceQueue(source)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	conn, err := amqp091.Dial(a.url)
+	if err != nil {
+		return nil, fmt.Errorf("connect rabbitmq: %w", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("open channel: %w", err)
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclarePassive(name, true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("inspect queue: %w", err)
+	}
+
+	// Deliveries stay unacked until they are nacked back, so the same
+	// message is not handed out twice within this listing.
+	var seen []amqp091.Delivery
+	defer func() {
+		for _, msg := range seen {
+			_ = msg.Nack(false, true)
+		}
+	}()
+
+	list := &DeadLetterList{Queue: name, Source: source, Total: q.Messages, Messages: []DeadLetter{}}
+	for len(list.Messages) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, ok, err := ch.Get(name, false)
+		if err != nil {
+			return nil, fmt.Errorf("get message: %w", err)
+		}
+		if !ok {
+			break
+		}
+		seen = append(seen, msg)
+		list.Messages = append(list.Messages, a.describe(msg, source))
+	}
+	return list, nil
+}
+
+func (a *DeadLetterAdmin) Replay(ctx context.Context, source string, ids []string, actor string) ([]ActionResult, error) {
+	return a.act(ctx, source, ids, ActionReplay, actor)
+}
+
+func (a *DeadLetterAdmin) Discard(ctx context.Context, source string, ids []string, actor string) ([]ActionResult, error) {
+	return a.act(ctx, source, ids, ActionDiscard, actor)
+}
+
+func (a *DeadLetterAdmin) act(ctx context.Context, source string, ids []string, action, actor string) ([]ActionResult, error) {
+	name, err := a.sourceQueue(source)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	conn, err := amqp091.Dial(a.url)
+	if err != nil {
+		return nil, fmt.Errorf("connect rabbitmq: %w", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("open channel: %w", err)
+	}
+	defer ch.Close()
+
+	pubCh, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("open publish channel: %w", err)
+	}
+	defer pubCh.Close()
+	if err := pubCh.Confirm(false); err != nil {
+		return nil, fmt.Errorf("enable confirms: %w", err)
+	}
+
+	q, err := ch.QueueDeclarePassive(name, true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("inspect queue: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	results := make([]ActionResult, 0, len(ids))
+	var skipped []amqp091.Delivery
+	defer func() {
+		for _, msg := range skipped {
+			_ = msg.Nack(false, true)
+		}
+	}()
+
+	for i := 0; i < q.Messages && len(wanted) > 0; i++ {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		msg, ok, err := ch.Get(name, false)
+		if err != nil {
+			return results, fmt.Errorf("get message: %w", err)
+		}
+		if !ok {
+			break
+		}
+
+		id := messageKey(msg)
+		if !wanted[id] {
+			skipped = append(skipped, msg)
+			continue
+		}
+		delete(wanted, id)
+
+		result := a.apply(ctx, pubCh, msg, action)
+		if result.Error == "" {
+			if err := a.audit(ctx, source, msg, action, actor, result); err != nil {
+				_ = msg.Nack(false, true)
+				return results, err
+			}
+			_ = msg.Ack(false)
+		} else {
+			skipped = append(skipped, msg)
+			if err := a.audit(ctx, source, msg, action, actor, result); err != nil {
+				a.logger.Warn("dead-letter audit failed", "queue", name, "message_id", id, "err", err)
+			}
+		}
+		results = append(results, result)
+	}
+
+	for _, id := range ids {
+		if wanted[id] {
+			results = append(results, ActionResult{MessageID: id, Result: ResultNotFound})
+		}
+	}
+	return results, nil
+}
+
+func (a *DeadLetterAdmin) apply(ctx context.Context, pubCh *amqp091.Channel, msg amqp091.Delivery, action string) ActionResult {
+	id := messageKey(msg)
+	if action == ActionDiscard {
+		return ActionResult{MessageID: id, Result: ResultDiscarded}
+	}
+
+	exchange, key := origin(msg)
+	headers := amqp091.Table{}
+	for k, v := range msg.Headers {
+		switch k {
+		case HeaderAttempts, HeaderLastError, HeaderOriginalExchange, HeaderOriginalRoutingKey, "x-death",
+			"x-first-death-exchange", "x-first-death-queue", "x-first-death-reason",
+			"x-last-death-exchange", "x-last-death-queue", "x-last-death-reason":
+			continue
+		}
+		headers[k] = v
+	}
+
+	err := publishConfirmed(ctx, pubCh, exchange, key, amqp091.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp091.Persistent,
+		CorrelationId: msg.CorrelationId,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		Body:          msg.Body,
+	})
+	if err != nil {
+		return ActionResult{MessageID: id, Result: ResultFailed, Error: err.Error()}
+	}
+	return ActionResult{MessageID: id, Result: ResultReplayed}
+}
+
+func (a *DeadLetterAdmin) audit(ctx context.Context, source string, msg amqp091.Delivery, action, actor string, result ActionResult) error {
+	_, key := origin(msg)
+	query := fmt.Sprintf(`
+		INSERT INTO %s (queue, source, message_id, routing_key, action, actor, result, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+	`, a.auditTable)
+	if _, err := a.pool.Exec(ctx, query, a.queue, source, result.MessageID, key, action, actor, result.Result, result.Error); err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+	return nil
+}
+
+func (a *DeadLetterAdmin) Audit(ctx context.Context, limit int) ([]AuditEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT id, queue, source, message_id, routing_key, action, actor, result, COALESCE(error, ''), created_at
+		FROM %s
+		ORDER BY id DESC
+		LIMIT $1
+	`, a.auditTable)
+	rows, err := a.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Queue, &e.Source, &e.MessageID, &e.RoutingKey, &e.Action, &e.Actor, &e.Result, &e.Error, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (a *DeadLetterAdmin) describe(msg amqp091.Delivery, source string) DeadLetter {
+	exchange, key := origin(msg)
+	lastError, _ := msg.Headers[HeaderLastError].(string)
+
+	dl := DeadLetter{
+		MessageID:  messageKey(msg),
+		Source:     source,
+		Exchange:   exchange,
+		RoutingKey: key,
+		Attempts:   attempts(msg),
+		LastError:  lastError,
+		Headers:    msg.Headers,
+		Body:       string(msg.Body),
+	}
+	if dl.Headers == nil {
+		dl.Headers = map[string]any{}
+	}
+	if a.decode != nil {
+		var payload any
+		msg.RoutingKey = key
+		env, err := EnvelopeFromDelivery(msg)
+		if err == nil {
+			env, err = contracts.Upcast(env)
+		}
+		if err == nil {
+			payload, err = a.decode(env.Type, env.Data)
+		}
+		if err != nil {
+			dl.DecodeError = err.Error()
+		} else {
+			dl.Payload = payload
+		}
+	}
+	return dl
+}
+
+// origin finds where a dead-lettered message was first published: our own
+// retry headers first, then the broker's x-death record for messages the
+// broker dead-lettered itself.
+func origin(msg amqp091.Delivery) (string, string) {
+	exchange, hasExchange := msg.Headers[HeaderOriginalExchange].(string)
+	key, hasKey := msg.Headers[HeaderOriginalRoutingKey].(string)
+	if hasExchange && hasKey {
+		return exchange, key
+	}
+
+	if deaths, ok := msg.Headers["x-death"].([]any); ok && len(deaths) > 0 {
+		if death, ok := deaths[len(deaths)-1].(amqp091.Table); ok {
+			exchange, _ = death["exchange"].(string)
+			if keys, ok := death["routing-keys"].([]any); ok && len(keys) > 0 {
+				key, _ = keys[0].(string)
+			}
+			return exchange, key
+		}
+	}
+	return msg.Exchange, msg.RoutingKey
+}
+
+func messageKey(msg amqp091.Delivery) string {
+	if msg.MessageId != "" {
+		return msg.MessageId
+	}
+	sum := sha256.Sum256(msg.Body)
+	return hex.EncodeToString(sum[:16])
+}
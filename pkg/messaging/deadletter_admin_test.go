@@ -0,0 +1,93 @@
+package messaging
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestDeadLetterSourceQueue(t *testing.T) {
+	a := &DeadLetterAdmin{queue: "payments.orders"}
+	tests := []struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{source: SourceDeadLetter, want: "payments.orders.dlq"},
+		{source: SourceParking, want: "payments.orders.parking"},
+		{source: "retry", wantErr: true},
+		{source: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			got, err := a.sourceQueue(tt.source)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnknownSource) {
+					t.Fatalf("sourceQueue() error = %v, want ErrUnknownSource", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("sourceQueue() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadLetterOrigin(t *testing.T) {
+	tests := []struct {
+		name         string
+		msg          amqp091.Delivery
+		wantExchange string
+		wantKey      string
+	}{
+		{
+			name: "retry headers",
+			msg: amqp091.Delivery{
+				Headers:    amqp091.Table{HeaderOriginalExchange: "orders.events", HeaderOriginalRoutingKey: "order.created"},
+				Exchange:   "payments.orders.dlx",
+				RoutingKey: "payments.orders",
+			},
+			wantExchange: "orders.events",
+			wantKey:      "order.created",
+		},
+		{
+			name: "broker x-death",
+			msg: amqp091.Delivery{
+				Headers: amqp091.Table{"x-death": []any{
+					amqp091.Table{"exchange": "payments.orders.retry", "routing-keys": []any{"payments.orders"}},
+					amqp091.Table{"exchange": "orders.events", "routing-keys": []any{"order.created"}},
+				}},
+				Exchange: "payments.orders.dlx",
+			},
+			wantExchange: "orders.events",
+			wantKey:      "order.created",
+		},
+		{
+			name:         "delivery fields",
+			msg:          amqp091.Delivery{Exchange: "orders.events", RoutingKey: "order.cancelled"},
+			wantExchange: "orders.events",
+			wantKey:      "order.cancelled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exchange, key := origin(tt.msg)
+			if exchange != tt.wantExchange || key != tt.wantKey {
+				t.Errorf("origin() = %q/%q, want %q/%q", exchange, key, tt.wantExchange, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	if got := messageKey(amqp091.Delivery{MessageId: "m-1", Body: []byte("x")}); got != "m-1" {
+		t.Errorf("messageKey() = %q, want the message id", got)
+	}
+	a := messageKey(amqp091.Delivery{Body: []byte("x")})
+	b := messageKey(amqp091.Delivery{Body: []byte("y")})
+	if a == "" || a == b || a != messageKey(amqp091.Delivery{Body: []byte("x")}) {
+		t.Errorf("body keys %q and %q are not stable and distinct", a, b)
+	}
+}
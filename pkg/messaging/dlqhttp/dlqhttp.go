@@ -0,0 +1,152 @@
+// Package dlqhttp serves the dead-letter admin API over HTTP. It lives
+// apart from messaging so that the messaging core does not depend on HTTP
+// or authentication.
+package dlqhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"gozon/pkg/auth"
+	"gozon/pkg/messaging"
+)
+
+const (
+	defaultDeadLetterLimit = 20
+	maxDeadLetterLimit     = 200
+)
+
+type deadLetterActionRequest struct {
+	Source     string   `json:"source"`
+	MessageIDs []string `json:"message_ids"`
+}
+
+// Handler serves the /admin/dead-letters endpoints over a
+// messaging.DeadLetterAdmin. Audit entries name the authenticated user as
+// the actor.
+type Handler struct {
+	admin  *messaging.DeadLetterAdmin
+	logger *slog.Logger
+}
+
+func New(admin *messaging.DeadLetterAdmin, logger *slog.Logger) *Handler {
+	return &Handler{admin: admin, logger: logger}
+}
+
+// Register adds the routes to mux, each wrapped in guard.
+func (h *Handler) Register(mux *http.ServeMux, guard func(http.Handler) http.Handler) {
+	mux.Handle("GET /admin/dead-letters", guard(http.HandlerFunc(h.list)))
+	mux.Handle("POST /admin/dead-letters/replay", guard(http.HandlerFunc(h.replay)))
+	mux.Handle("POST /admin/dead-letters/discard", guard(http.HandlerFunc(h.discard)))
+	mux.Handle("GET /admin/dead-letters/audit", guard(http.HandlerFunc(h.audit)))
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = messaging.SourceDeadLetter
+	}
+	limit, ok := parseDeadLetterLimit(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := h.admin.List(r.Context(), source, limit)
+	if err != nil {
+		h.fail(w, "list dead letters", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *Handler) replay(w http.ResponseWriter, r *http.Request) {
+	h.action(w, r, messaging.ActionReplay)
+}
+
+func (h *Handler) discard(w http.ResponseWriter, r *http.Request) {
+	h.action(w, r, messaging.ActionDiscard)
+}
+
+func (h *Handler) action(w http.ResponseWriter, r *http.Request, action string) {
+	actor, err := auth.UserFrom(r.Context())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var req deadLetterActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.MessageIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "message_ids is required")
+		return
+	}
+	if req.Source == "" {
+		req.Source = messaging.SourceDeadLetter
+	}
+
+	var results []messaging.ActionResult
+	if action == messaging.ActionReplay {
+		results, err = h.admin.Replay(r.Context(), req.Source, req.MessageIDs, actor.String())
+	} else {
+		results, err = h.admin.Discard(r.Context(), req.Source, req.MessageIDs, actor.String())
+	}
+	if err != nil {
+		h.fail(w, action+" dead letters", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+func (h *Handler) audit(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseDeadLetterLimit(w, r)
+	if !ok {
+		return
+	}
+	entries, err := h.admin.Audit(r.Context(), limit)
+	if err != nil {
+		h.fail(w, "dead letter audit", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
+
+func (h *Handler) fail(w http.ResponseWriter, op string, err error) {
+	if errors.Is(err, messaging.ErrUnknownSource) {
+		writeError(w, http.StatusBadRequest, "source must be dlq or parking")
+		return
+	}
+	h.logger.Error(op, "err", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+func parseDeadLetterLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultDeadLetterLimit, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return 0, false
+	}
+	if limit > maxDeadLetterLimit {
+		limit = maxDeadLetterLimit
+	}
+	return limit, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,73 @@
+package dlqhttp
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gozon/pkg/auth"
+
+	"github.com/google/uuid"
+)
+
+func TestHandlerRequiresAdmin(t *testing.T) {
+	mux := http.NewServeMux()
+	New(nil, slog.Default()).Register(mux, auth.RequireAdmin)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		roles  []string
+		user   bool
+		body   string
+		want   int
+	}{
+		{name: "list anonymous", method: http.MethodGet, path: "/admin/dead-letters", want: http.StatusUnauthorized},
+		{name: "list without role", method: http.MethodGet, path: "/admin/dead-letters", user: true, want: http.StatusForbidden},
+		{name: "replay without role", method: http.MethodPost, path: "/admin/dead-letters/replay", user: true, body: `{"message_ids":["a"]}`, want: http.StatusForbidden},
+		{name: "discard anonymous", method: http.MethodPost, path: "/admin/dead-letters/discard", body: `{"message_ids":["a"]}`, want: http.StatusUnauthorized},
+		{name: "audit without role", method: http.MethodGet, path: "/admin/dead-letters/audit", user: true, want: http.StatusForbidden},
+		{name: "replay invalid body", method: http.MethodPost, path: "/admin/dead-letters/replay", user: true, roles: []string{auth.RoleAdmin}, body: `{`, want: http.StatusBadRequest},
+		{name: "replay without ids", method: http.MethodPost, path: "/admin/dead-letters/replay", user: true, roles: []string{auth.RoleAdmin}, body: `{}`, want: http.StatusBadRequest},
+		{name: "list invalid limit", method: http.MethodGet, path: "/admin/dead-letters?limit=-1", user: true, roles: []string{auth.RoleAdmin}, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.user {
+				req = req.WithContext(auth.WithRoles(auth.WithUser(req.Context(), uuid.New()), tt.roles...))
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseDeadLetterLimit(t *testing.T) {
+	tests := []struct {
+		query  string
+		want   int
+		wantOK bool
+	}{
+		{query: "", want: defaultDeadLetterLimit, wantOK: true},
+		{query: "limit=5", want: 5, wantOK: true},
+		{query: "limit=100000", want: maxDeadLetterLimit, wantOK: true},
+		{query: "limit=0"},
+		{query: "limit=x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			got, ok := parseDeadLetterLimit(rec, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseDeadLetterLimit() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gozon/pkg/contracts"
+	"gozon/pkg/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	HeaderSchemaVersion = "schema_version"
+	HeaderCausationID   = "causation_id"
+)
+
+// InsertOutbox stores env in the outbox table within the caller's
+// transaction, together with the trace context of ctx so that the
+// dispatcher can publish it as part of the same trace.
+func InsertOutbox(ctx context.Context, tx pgx.Tx, table string, env contracts.Envelope) error {
+	ctx, span := tracer.Start(ctx, table+" insert", trace.WithAttributes(
+		attribute.String("messaging.message.id", env.ID),
+		attribute.String("event.type", env.Type),
+	))
+	defer span.End()
+
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("marshal envelope: %w", err)
+	}
+	traceContext, err := json.Marshal(tracing.Inject(ctx))
+	if err != nil {
+		return fmt.Errorf("marshal trace context: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (event_id, event_type, payload, trace_context)
+		VALUES ($1, $2, $3, $4)`, table)
+	if _, err := tx.Exec(ctx, query, env.ID, env.Type, payload, traceContext); err != nil {
+		tracing.Fail(span, err)
+		return fmt.Errorf("insert outbox: %w", err)
+	}
+	return nil
+}
+
+func envelopePublishing(env contracts.Envelope, encoding Encoding) (amqp091.Publishing, error) {
+	headers := amqp091.Table{HeaderSchemaVersion: int32(env.SchemaVersion)}
+	if env.CausationID != "" {
+		headers[HeaderCausationID] = env.CausationID
+	}
+
+	msg := amqp091.Publishing{
+		Headers:       headers,
+		DeliveryMode:  amqp091.Persistent,
+		MessageId:     env.ID,
+		CorrelationId: env.CorrelationID,
+		Timestamp:     env.OccurredAt,
+		Type:          env.Type,
+		AppId:         env.Producer,
+	}
+
+	switch encoding {
+	case EncodingCloudEventsBinary:
+		cloudEventsBinary(&msg, env)
+	case EncodingCloudEventsStructured:
+		if err := cloudEventsStructured(&msg, env); err != nil {
+			return amqp091.Publishing{}, err
+		}
+	default:
+		body, err := json.Marshal(env)
+		if err != nil {
+			return amqp091.Publishing{}, fmt.Errorf("marshal envelope: %w", err)
+		}
+		msg.ContentType = "application/json"
+		msg.Body = body
+	}
+	return msg, nil
+}
+
+// EnvelopeFromDelivery reads the envelope from msg in any of the supported
+// encodings. Bare event JSON from producers that predate envelopes is
+// wrapped using the AMQP properties, falling back to the routing key for
+// the event type.
+func EnvelopeFromDelivery(msg amqp091.Delivery) (contracts.Envelope, error) {
+	switch {
+	case isCloudEventsStructured(msg):
+		return decodeCloudEventsStructured(msg)
+	case isCloudEventsBinary(msg):
+		return decodeCloudEventsBinary(msg)
+	}
+
+	env, err := contracts.UnmarshalEnvelope(msg.Body)
+	if err == nil {
+		return env, nil
+	}
+	if !errors.Is(err, contracts.ErrNotEnvelope) {
+		return contracts.Envelope{}, fmt.Errorf("decode envelope: %w", err)
+	}
+
+	eventType := msg.Type
+	if eventType == "" {
+		eventType = msg.RoutingKey
+	}
+	return contracts.Envelope{
+		ID:            msg.MessageId,
+		Type:          eventType,
+		SchemaVersion: contracts.DefaultSchemaVersion,
+		OccurredAt:    msg.Timestamp,
+		CorrelationID: msg.CorrelationId,
+		Producer:      msg.AppId,
+		Data:          msg.Body,
+	}, nil
+}
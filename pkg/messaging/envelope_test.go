@@ -0,0 +1,146 @@
+package messaging
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"gozon/pkg/contracts"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func testEnvelope() contracts.Envelope {
+	return contracts.Envelope{
+		ID:            "evt-2",
+		Type:          contracts.EventPaymentProcessed,
+		SchemaVersion: 1,
+		OccurredAt:    time.Date(2024, 5, 1, 12, 0, 0, 123000000, time.UTC),
+		CorrelationID: "evt-1",
+		CausationID:   "evt-1",
+		Producer:      contracts.ProducerPayments,
+		Data:          json.RawMessage(`{"order_id":"o-1","status":"succeeded"}`),
+	}
+}
+
+// delivered turns a publishing into what a consumer receives for it.
+func delivered(msg amqp091.Publishing, routingKey string) amqp091.Delivery {
+	return amqp091.Delivery{
+		Headers:       msg.Headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  msg.DeliveryMode,
+		CorrelationId: msg.CorrelationId,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		Body:          msg.Body,
+		RoutingKey:    routingKey,
+	}
+}
+
+func TestEnvelopePublishing(t *testing.T) {
+	env := testEnvelope()
+	msg, err := envelopePublishing(env, EncodingEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentType != "application/json" || msg.DeliveryMode != amqp091.Persistent {
+		t.Errorf("content type %q, delivery mode %d", msg.ContentType, msg.DeliveryMode)
+	}
+	if msg.MessageId != env.ID || msg.CorrelationId != env.CorrelationID || msg.Type != env.Type || msg.AppId != env.Producer {
+		t.Errorf("properties = %+v, want mirrored from %+v", msg, env)
+	}
+	if msg.Headers[HeaderSchemaVersion] != int32(1) || msg.Headers[HeaderCausationID] != "evt-1" {
+		t.Errorf("headers = %v", msg.Headers)
+	}
+
+	noCause := env
+	noCause.CausationID = ""
+	msg, err = envelopePublishing(noCause, EncodingEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.Headers[HeaderCausationID]; ok {
+		t.Error("causation header set for an event without a cause")
+	}
+}
+
+func TestEnvelopeFromDelivery(t *testing.T) {
+	env := testEnvelope()
+	msg, err := envelopePublishing(env, EncodingEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := EnvelopeFromDelivery(delivered(msg, env.Type))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEnvelope(t, got, env)
+}
+
+func TestEnvelopeFromLegacyDelivery(t *testing.T) {
+	body := []byte(`{"event_id":"evt-0","order_id":"o-1","amount":100}`)
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		msg      amqp091.Delivery
+		wantType string
+	}{
+		{
+			name:     "type property",
+			msg:      amqp091.Delivery{Type: contracts.EventOrderCreated, RoutingKey: "ignored", MessageId: "evt-0", Timestamp: ts, AppId: "orders-service", Body: body},
+			wantType: contracts.EventOrderCreated,
+		},
+		{
+			name:     "routing key fallback",
+			msg:      amqp091.Delivery{RoutingKey: contracts.EventOrderCreated, MessageId: "evt-0", Timestamp: ts, AppId: "orders-service", Body: body},
+			wantType: contracts.EventOrderCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EnvelopeFromDelivery(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := contracts.Envelope{
+				ID:            "evt-0",
+				Type:          tt.wantType,
+				SchemaVersion: contracts.DefaultSchemaVersion,
+				OccurredAt:    ts,
+				Producer:      "orders-service",
+				Data:          body,
+			}
+			assertEnvelope(t, got, want)
+		})
+	}
+
+	if _, err := EnvelopeFromDelivery(amqp091.Delivery{Body: []byte(`not json`)}); err == nil {
+		t.Error("EnvelopeFromDelivery() accepted a body that is not JSON")
+	}
+}
+
+func assertEnvelope(t *testing.T, got, want contracts.Envelope) {
+	t.Helper()
+	if !got.OccurredAt.Equal(want.OccurredAt) {
+		t.Errorf("OccurredAt = %s, want %s", got.OccurredAt, want.OccurredAt)
+	}
+	got.OccurredAt, want.OccurredAt = time.Time{}, time.Time{}
+
+	var gotData, wantData any
+	if err := json.Unmarshal(got.Data, &gotData); err != nil {
+		t.Fatalf("Data is not JSON: %v", err)
+	}
+	_ = json.Unmarshal(want.Data, &wantData)
+	if !reflect.DeepEqual(gotData, wantData) {
+		t.Errorf("Data = %s, want %s", got.Data, want.Data)
+	}
+	got.Data, want.Data = nil, nil
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("envelope = %+v, want %+v", got, want)
+	}
+}
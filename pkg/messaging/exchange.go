@@ -0,0 +1,42 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// ErrExchangeKindMismatch means the exchange already exists with another
+// type. RabbitMQ never changes the type of an existing exchange.
+var ErrExchangeKindMismatch = errors.New("exchange exists with a different kind")
+
+func validateExchangeKind(kind string) error {
+	switch kind {
+	case amqp091.ExchangeTopic, amqp091.ExchangeDirect, amqp091.ExchangeFanout, amqp091.ExchangeHeaders:
+		return nil
+	}
+	return fmt.Errorf("unknown exchange kind %q", kind)
+}
+
+type exchangeDeclarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error
+}
+
+// declareExchange declares a durable exchange. When the broker refuses
+// because the exchange was created with another type, typically fanout
+// before routing keys were introduced, the error says how to fix it.
+func declareExchange(ch exchangeDeclarer, name, kind string) error {
+	err := ch.ExchangeDeclare(name, kind, true, false, false, false, nil)
+	var amqpErr *amqp091.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp091.PreconditionFailed {
+		return fmt.Errorf(
+			"%w: %q cannot be declared as %s; set the exchange kind to the existing type or delete the exchange so it is recreated (%s)",
+			ErrExchangeKindMismatch, name, kind, amqpErr.Reason,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("declare exchange: %w", err)
+	}
+	return nil
+}
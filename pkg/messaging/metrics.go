@@ -0,0 +1,34 @@
+package messaging
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Consumer outcomes. Retry, dead_letter and parked are the failure paths:
+// the delivery is acked only after its copy has been republished.
+const (
+	outcomeAck        = "ack"
+	outcomeRetry      = "retry"
+	outcomeDeadLetter = "dead_letter"
+	outcomeParked     = "parked"
+	outcomeRequeue    = "requeue"
+)
+
+var (
+	consumerMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "messaging_consumer_messages_total",
+		Help: "Deliveries settled by a consumer, by outcome.",
+	}, []string{"queue", "outcome"})
+
+	outboxRows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "messaging_outbox_rows",
+		Help: "Outbox rows that have not been sent, by status.",
+	}, []string{"table", "status"})
+
+	outboxPublishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "messaging_outbox_publish_duration_seconds",
+		Help:    "Time to publish one outbox row and get the broker confirm.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"table", "result"})
+)
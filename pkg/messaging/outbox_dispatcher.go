@@ -6,152 +6,360 @@ import (
 	"log/slog"
 	"time"
 
+	"gozon/pkg/contracts"
+	"gozon/pkg/tracing"
+
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// outboxDB is the part of *pgxpool.Pool the dispatcher uses.
+type outboxDB interface {
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+const (
+	// publishTimeout bounds a single publish.
+	publishTimeout = 5 * time.Second
+	// leaseMargin covers the status updates around the publishes of a
+	// batch.
+	leaseMargin = 30 * time.Second
 )
 
 type OutboxDispatcher struct {
-	pool      *pgxpool.Pool
-	publisher Publisher
-	table     string
-	interval  time.Duration
-	batchSize int
-	logger    *slog.Logger
+	pool         outboxDB
+	listenConfig *pgx.ConnConfig
+	publisher    Publisher
+	table        string
+	interval     time.Duration
+	batchSize    int
+	maxAttempts  int
+	lease        time.Duration
+	logger       *slog.Logger
 }
 
 type outboxRow struct {
-	ID        int64
-	EventType string
-	Payload   []byte
-	Attempts  int
+	ID           int64
+	EventID      string
+	EventType    string
+	Payload      []byte
+	Attempts     int
+	CreatedAt    time.Time
+	TraceContext map[string]string
 }
 
-func NewOutboxDispatcher(pool *pgxpool.Pool, publisher Publisher, table string, interval time.Duration, batch int, logger *slog.Logger) *OutboxDispatcher {
+// NewOutboxDispatcher creates a dispatcher that gives up on a row after
+// maxAttempts failed publishes and marks it dead; zero or less retries
+// forever.
+//
+// Locked rows are leased to the dispatcher for lease; after that another
+// dispatcher may pick them up again. Zero or less derives the lease from
+// the batch size, so that a batch whose every publish times out still fits.
+func NewOutboxDispatcher(pool *pgxpool.Pool, publisher Publisher, table string, interval time.Duration, batch, maxAttempts int, lease time.Duration, logger *slog.Logger) *OutboxDispatcher {
+	if lease <= 0 {
+		lease = defaultLease(batch)
+	}
 	return &OutboxDispatcher{
-		pool:      pool,
-		publisher: publisher,
-		table:     table,
-		interval:  interval,
-		batchSize: batch,
-		logger:    logger,
+		pool:         pool,
+		listenConfig: pool.Config().ConnConfig,
+		publisher:    publisher,
+		table:        table,
+		interval:     interval,
+		batchSize:    batch,
+		maxAttempts:  maxAttempts,
+		lease:        lease,
+		logger:       logger,
 	}
 }
 
+func defaultLease(batch int) time.Duration {
+	return time.Duration(max(batch, 1))*publishTimeout + leaseMargin
+}
+
+// Start dispatches whenever the outbox trigger signals an insert on the
+// channel named after the table, and on every tick as a safety net for
+// missed notifications and rows waiting for a retry.
 func (d *OutboxDispatcher) Start(ctx context.Context) {
-	go d.loop(ctx)
+	wake := make(chan struct{}, 1)
+	go d.listen(ctx, wake)
+	go d.loop(ctx, wake)
+	go d.observe(ctx)
+}
+
+const outboxObserveInterval = 15 * time.Second
+
+// observe keeps the backlog gauges current. Sent rows are not counted: the
+// janitor removes them and they say nothing about the backlog.
+func (d *OutboxDispatcher) observe(ctx context.Context) {
+	ticker := time.NewTicker(outboxObserveInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.countRows(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Warn("outbox count failed", "table", d.table, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *OutboxDispatcher) countRows(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		SELECT status, COUNT(*)
+		FROM %s
+		WHERE status IN ('pending', 'processing', 'dead')
+		GROUP BY status`, d.table)
+	rows, err := d.pool.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{"pending": 0, "processing": 0, "dead": 0}
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return err
+		}
+		counts[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for status, n := range counts {
+		outboxRows.WithLabelValues(d.table, status).Set(float64(n))
+	}
+	return nil
 }
 
-func (d *OutboxDispatcher) loop(ctx context.Context) {
+func (d *OutboxDispatcher) loop(ctx context.Context, wake <-chan struct{}) {
 	ticker := time.NewTicker(d.interval)
 	defer ticker.Stop()
 
 	for {
-		if err := d.dispatch(ctx); err != nil {
+		n, err := d.dispatch(ctx)
+		if err != nil {
 			d.logger.Error("outbox dispatch failed", "table", d.table, "err", err)
 		}
+		if err == nil && n == d.batchSize {
+			continue
+		}
 
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+		case <-wake:
 		}
 	}
 }
 
-func (d *OutboxDispatcher) dispatch(ctx context.Context) error {
-	rows, err := d.lockRows(ctx)
+func (d *OutboxDispatcher) listen(ctx context.Context, wake chan<- struct{}) {
+	for attempt := 1; ; attempt++ {
+		err := d.waitForNotifications(ctx, wake)
+		if ctx.Err() != nil {
+			return
+		}
+		d.logger.Warn("outbox listener interrupted", "table", d.table, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoffDelay(attempt)):
+		}
+	}
+}
+
+func (d *OutboxDispatcher) waitForNotifications(ctx context.Context, wake chan<- struct{}) error {
+	// LISTEN needs a session of its own; a pooled connection would be
+	// handed to other queries between notifications.
+	conn, err := pgx.ConnectConfig(ctx, d.listenConfig.Copy())
 	if err != nil {
-		return err
+		return fmt.Errorf("connect listener: %w", err)
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{d.table}.Sanitize()); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	// Rows inserted while the listener was down would otherwise wait for the
+	// next tick.
+	signal(wake)
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		signal(wake)
 	}
-	if len(rows) == 0 {
-		return nil
+}
+
+func signal(wake chan<- struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *OutboxDispatcher) dispatch(ctx context.Context) (int, error) {
+	rows, leasedUntil, err := d.lockRows(ctx)
+	if err != nil {
+		return 0, err
 	}
 
-	for _, row := range rows {
+	for i, row := range rows {
+		// A publish that could outlast the lease may race another
+		// dispatcher that has taken the row over. Leave the rest for
+		// whoever holds the next lease.
+		if time.Until(leasedUntil) < publishTimeout {
+			d.logger.Warn("outbox lease running out", "table", d.table, "unpublished", len(rows)-i)
+			break
+		}
 		if err := d.publishOne(ctx, row); err != nil {
 			d.logger.Warn("publish event failed", "table", d.table, "row_id", row.ID, "err", err)
 		}
 	}
-	return nil
+	return len(rows), nil
 }
 
-func (d *OutboxDispatcher) lockRows(ctx context.Context) ([]outboxRow, error) {
+// lockRows leases a batch of due rows to this dispatcher and returns them
+// with the time the lease ends.
+func (d *OutboxDispatcher) lockRows(ctx context.Context) ([]outboxRow, time.Time, error) {
 	tx, err := d.pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 	defer tx.Rollback(ctx)
 
 	query := fmt.Sprintf(`
-		SELECT id, event_type, payload, attempts
+		SELECT id, event_id::text, event_type, payload, attempts, created_at, trace_context
 		FROM %s
-		WHERE (status = 'pending' OR (status = 'processing' AND next_retry <= NOW()))
+		WHERE status IN ('pending', 'processing') AND next_retry <= NOW()
 		ORDER BY id
 		LIMIT $1
 		FOR UPDATE SKIP LOCKED`, d.table)
 
 	rows, err := tx.Query(ctx, query, d.batchSize)
 	if err != nil {
-		return nil, fmt.Errorf("query outbox: %w", err)
+		return nil, time.Time{}, fmt.Errorf("query outbox: %w", err)
 	}
 	defer rows.Close()
 
 	var items []outboxRow
 	for rows.Next() {
 		var row outboxRow
-		if err := rows.Scan(&row.ID, &row.EventType, &row.Payload, &row.Attempts); err != nil {
-			return nil, err
+		if err := rows.Scan(&row.ID, &row.EventID, &row.EventType, &row.Payload, &row.Attempts, &row.CreatedAt, &row.TraceContext); err != nil {
+			return nil, time.Time{}, err
 		}
 		items = append(items, row)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
-	releaseAt := time.Now().Add(30 * time.Second)
+	releaseAt := time.Now().Add(d.lease)
 	for _, row := range items {
 		updateQuery := fmt.Sprintf(`
 			UPDATE %s
 			SET status = 'processing', next_retry = $2, updated_at = NOW()
 			WHERE id = $1`, d.table)
 		if _, err := tx.Exec(ctx, updateQuery, row.ID, releaseAt); err != nil {
-			return nil, err
+			return nil, time.Time{}, err
 		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
-	return items, nil
+	return items, releaseAt, nil
 }
 
-func (d *OutboxDispatcher) publishOne(ctx context.Context, row outboxRow) error {
-	pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// publishOne continues the trace that was active when the row was
+// inserted.
+func (d *OutboxDispatcher) publishOne(ctx context.Context, row outboxRow) (err error) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, row.TraceContext), d.table+" dispatch", trace.WithAttributes(
+		attribute.String("messaging.message.id", row.EventID),
+		attribute.String("event.type", row.EventType),
+		attribute.Int("outbox.attempt", row.Attempts+1),
+	))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+
+	pubCtx, cancel := context.WithTimeout(ctx, publishTimeout)
 	defer cancel()
 
-	if err := d.publisher.Publish(pubCtx, "", row.Payload); err != nil {
+	start := time.Now()
+	if err := d.publisher.Publish(pubCtx, row.envelope()); err != nil {
+		outboxPublishDuration.WithLabelValues(d.table, "error").Observe(time.Since(start).Seconds())
 		return d.markFailure(ctx, row, err)
 	}
+	outboxPublishDuration.WithLabelValues(d.table, "ok").Observe(time.Since(start).Seconds())
 
 	update := fmt.Sprintf(`
 		UPDATE %s
-		SET status = 'sent', updated_at = NOW()
+		SET status = 'sent', last_error = NULL, updated_at = NOW()
 		WHERE id = $1`, d.table)
-	_, err := d.pool.Exec(ctx, update, row.ID)
+	_, err = d.pool.Exec(ctx, update, row.ID)
 	return err
 }
 
+// envelope returns the stored envelope, wrapping rows written as bare event
+// JSON before envelopes were introduced.
+func (row outboxRow) envelope() contracts.Envelope {
+	if env, err := contracts.UnmarshalEnvelope(row.Payload); err == nil {
+		return env
+	}
+	return contracts.Envelope{
+		ID:            row.EventID,
+		Type:          row.EventType,
+		SchemaVersion: contracts.DefaultSchemaVersion,
+		OccurredAt:    row.CreatedAt,
+		CorrelationID: row.EventID,
+		Data:          row.Payload,
+	}
+}
+
 func (d *OutboxDispatcher) markFailure(ctx context.Context, row outboxRow, publishErr error) error {
-	delay := retryDelay(row.Attempts + 1)
-	nextRetry := time.Now().Add(delay)
+	attempts := row.Attempts + 1
+	lastError := truncate(publishErr.Error(), 1024)
+	if d.maxAttempts > 0 && attempts >= d.maxAttempts {
+		query := fmt.Sprintf(`
+			UPDATE %s
+			SET status = 'dead',
+			    attempts = $2,
+			    last_error = $3,
+			    updated_at = NOW()
+			WHERE id = $1`, d.table)
+		if _, err := d.pool.Exec(ctx, query, row.ID, attempts, lastError); err != nil {
+			return fmt.Errorf("mark dead: %w", err)
+		}
+		d.logger.Error("outbox event is dead", "table", d.table, "row_id", row.ID, "event_id", row.EventID, "attempts", attempts, "err", publishErr)
+		return publishErr
+	}
+
+	nextRetry := time.Now().Add(retryDelay(attempts))
 	query := fmt.Sprintf(`
 		UPDATE %s
 		SET status = 'pending',
-		    attempts = attempts + 1,
-	    next_retry = $2,
+		    attempts = $2,
+		    next_retry = $3,
+		    last_error = $4,
 		    updated_at = NOW()
 		WHERE id = $1`, d.table)
-	if _, err := d.pool.Exec(ctx, query, row.ID, nextRetry); err != nil {
+	if _, err := d.pool.Exec(ctx, query, row.ID, attempts, nextRetry, lastError); err != nil {
 		return fmt.Errorf("update retry: %w", err)
 	}
 	return publishErr
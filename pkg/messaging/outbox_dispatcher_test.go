@@ -0,0 +1,286 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gozon/pkg/contracts"
+	"gozon/pkg/pgtest"
+)
+
+type fakeOutboxRow struct {
+	status    string
+	attempts  int
+	nextRetry time.Time
+	lastError string
+}
+
+// fakeOutbox scripts the dispatcher's statements against an in-memory
+// outbox table. Due rows are judged by clock rather than the wall clock, so
+// a test can step past a lease.
+type fakeOutbox struct {
+	db *pgtest.DB
+
+	mu    sync.Mutex
+	clock time.Time
+	rows  map[int64]*fakeOutboxRow
+}
+
+func newFakeOutbox(n int) *fakeOutbox {
+	f := &fakeOutbox{db: pgtest.New(), clock: time.Now(), rows: map[int64]*fakeOutboxRow{}}
+	for id := int64(1); id <= int64(n); id++ {
+		f.rows[id] = &fakeOutboxRow{status: "pending"}
+	}
+	f.db.
+		On("FOR UPDATE SKIP LOCKED", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			var due []int64
+			for id, row := range f.rows {
+				if (row.status == "pending" || row.status == "processing") && !row.nextRetry.After(f.clock) {
+					due = append(due, id)
+				}
+			}
+			sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+			if limit := args[0].(int); len(due) > limit {
+				due = due[:limit]
+			}
+			var out [][]any
+			for _, id := range due {
+				env := contracts.Envelope{ID: "evt", Type: contracts.EventOrderCreated, SchemaVersion: 1, Data: []byte(`{}`)}
+				payload, _ := json.Marshal(env)
+				out = append(out, []any{id, "evt", env.Type, payload, f.rows[id].attempts, f.clock, nil})
+			}
+			return pgtest.Rows(out...)
+		}).
+		On("SET status = 'processing'", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			row := f.rows[args[0].(int64)]
+			row.status, row.nextRetry = "processing", args[1].(time.Time)
+			return pgtest.Affected(1)
+		}).
+		On("SET status = 'sent'", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.rows[args[0].(int64)].status = "sent"
+			return pgtest.Affected(1)
+		}).
+		On("SET status = 'pending'", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			row := f.rows[args[0].(int64)]
+			row.status, row.attempts, row.nextRetry, row.lastError = "pending", args[1].(int), args[2].(time.Time), args[3].(string)
+			return pgtest.Affected(1)
+		}).
+		On("SET status = 'dead'", func(args []any) pgtest.Result {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			row := f.rows[args[0].(int64)]
+			row.status, row.attempts, row.lastError = "dead", args[1].(int), args[2].(string)
+			return pgtest.Affected(1)
+		})
+	return f
+}
+
+func (f *fakeOutbox) row(id int64) fakeOutboxRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.rows[id]
+}
+
+func (f *fakeOutbox) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clock = f.clock.Add(d)
+}
+
+func (f *fakeOutbox) dispatcher(publisher Publisher, batch, maxAttempts int, lease time.Duration) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		pool:        f.db,
+		publisher:   publisher,
+		table:       "order_outbox",
+		batchSize:   batch,
+		maxAttempts: maxAttempts,
+		lease:       lease,
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// countingPublisher counts publishes, sleeping delay in each and failing
+// with err when it is set.
+type countingPublisher struct {
+	mu    sync.Mutex
+	n     int
+	delay time.Duration
+	err   error
+}
+
+func (p *countingPublisher) Publish(ctx context.Context, env contracts.Envelope) error {
+	time.Sleep(p.delay)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n++
+	return p.err
+}
+
+func (p *countingPublisher) Close() error { return nil }
+
+func (p *countingPublisher) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.n
+}
+
+func TestDefaultLease(t *testing.T) {
+	for _, batch := range []int{0, 1, 32, 500} {
+		lease := defaultLease(batch)
+		if worst := time.Duration(max(batch, 1)) * publishTimeout; lease <= worst {
+			t.Errorf("defaultLease(%d) = %s, want more than %s", batch, lease, worst)
+		}
+	}
+}
+
+func TestLeaseExpiryAndReacquisition(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOutbox(2)
+	lease := defaultLease(2)
+
+	// The first dispatcher leases the batch and then stalls without
+	// publishing.
+	first := f.dispatcher(&countingPublisher{}, 2, 0, lease)
+	rows, leasedUntil, err := first.lockRows(ctx)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("lockRows() = %d rows, %v", len(rows), err)
+	}
+	if got := f.row(1).nextRetry; !got.Equal(leasedUntil) || time.Until(leasedUntil) < lease-time.Second {
+		t.Errorf("next_retry = %s, lease until %s, want about now + %s", got, leasedUntil, lease)
+	}
+
+	// While the lease holds, a second dispatcher finds nothing to do.
+	pub := &countingPublisher{}
+	second := f.dispatcher(pub, 2, 0, lease)
+	if n, err := second.dispatch(ctx); err != nil || n != 0 {
+		t.Fatalf("dispatch() under a live lease = %d, %v", n, err)
+	}
+
+	// Once it runs out, the rows are taken over and published.
+	f.advance(lease + time.Second)
+	if n, err := second.dispatch(ctx); err != nil || n != 2 {
+		t.Fatalf("dispatch() after the lease = %d, %v", n, err)
+	}
+	if pub.count() != 2 || f.row(1).status != "sent" || f.row(2).status != "sent" {
+		t.Errorf("published %d, statuses %s/%s", pub.count(), f.row(1).status, f.row(2).status)
+	}
+}
+
+func TestDispatchStopsBeforeLeaseRunsOut(t *testing.T) {
+	f := newFakeOutbox(3)
+	// The lease covers one publish plus a little; the slow first publish
+	// eats the slack, so starting the second could outlast the lease.
+	pub := &countingPublisher{delay: 300 * time.Millisecond}
+	d := f.dispatcher(pub, 3, 0, publishTimeout+200*time.Millisecond)
+
+	n, err := d.dispatch(context.Background())
+	if err != nil || n != 3 {
+		t.Fatalf("dispatch() = %d, %v", n, err)
+	}
+	if pub.count() != 1 {
+		t.Fatalf("published %d rows, want 1 before the lease ran short", pub.count())
+	}
+	for id := int64(2); id <= 3; id++ {
+		if row := f.row(id); row.status != "processing" {
+			t.Errorf("row %d status = %s, want it left leased for the next owner", id, row.status)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{-1, time.Second},
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{5, 32 * time.Second},
+		{50, 32 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestMarkFailureCountsAttemptsUntilDead(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOutbox(1)
+	pub := &countingPublisher{err: errors.New("broker unavailable")}
+	d := f.dispatcher(pub, 1, 3, defaultLease(1))
+
+	for attempt := 1; attempt < 3; attempt++ {
+		before := time.Now()
+		if _, err := d.dispatch(ctx); err != nil {
+			t.Fatal(err)
+		}
+		row := f.row(1)
+		if row.status != "pending" || row.attempts != attempt || row.lastError != "broker unavailable" {
+			t.Fatalf("after failure %d row = %+v, want pending with %d attempts", attempt, row, attempt)
+		}
+		if delay := row.nextRetry.Sub(before); delay < retryDelay(attempt) || delay > retryDelay(attempt)+time.Second {
+			t.Errorf("after failure %d next_retry is %s away, want %s", attempt, delay, retryDelay(attempt))
+		}
+
+		// The row is not retried before its time.
+		if n, err := d.dispatch(ctx); err != nil || n != 0 {
+			t.Fatalf("dispatch() before next_retry = %d, %v", n, err)
+		}
+		f.advance(retryDelay(attempt) + time.Second)
+	}
+
+	// The third failure reaches maxAttempts.
+	if _, err := d.dispatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if row := f.row(1); row.status != "dead" || row.attempts != 3 {
+		t.Fatalf("after failure 3 row = %+v, want dead with 3 attempts", row)
+	}
+	if pub.count() != 3 {
+		t.Errorf("published %d times, want 3", pub.count())
+	}
+
+	// A dead row is never picked up again.
+	f.advance(time.Hour)
+	if n, err := d.dispatch(ctx); err != nil || n != 0 || pub.count() != 3 {
+		t.Errorf("dispatch() of a dead row = %d, %v after %d publishes", n, err, pub.count())
+	}
+}
+
+func TestMarkFailureWithoutLimit(t *testing.T) {
+	f := newFakeOutbox(1)
+	d := f.dispatcher(&countingPublisher{}, 1, 0, defaultLease(1))
+	long := errors.New(strings.Repeat("x", 2000))
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		if err := d.markFailure(context.Background(), outboxRow{ID: 1, Attempts: attempt - 1}, long); !errors.Is(err, long) {
+			t.Fatalf("markFailure() = %v, want the publish error back", err)
+		}
+	}
+	row := f.row(1)
+	if row.status != "pending" || row.attempts != 10 {
+		t.Errorf("row = %s with %d attempts, want pending after 10 with no limit", row.status, row.attempts)
+	}
+	if len(row.lastError) != 1024 {
+		t.Errorf("last_error is %d bytes, want it truncated to 1024", len(row.lastError))
+	}
+}
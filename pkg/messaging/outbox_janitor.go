@@ -0,0 +1,199 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrArchiveConflict means sent rows could not be archived because their id
+// is already in the archive. They stay in the outbox until an operator
+// resolves the clash; the archived copy is never overwritten.
+var ErrArchiveConflict = errors.New("outbox rows already archived")
+
+// janitorDB is the part of *pgxpool.Pool the janitor uses.
+type janitorDB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// RetentionPolicy controls how long the janitor keeps delivered outbox rows
+// and inbox deduplication records. A zero retention disables that cleanup.
+// With Archive set, sent rows are moved to the "<outbox>_archive" table
+// instead of being deleted.
+type RetentionPolicy struct {
+	Interval       time.Duration
+	SentRetention  time.Duration
+	InboxRetention time.Duration
+	BatchSize      int
+	Archive        bool
+}
+
+// InboxTable names an inbox table and the column holding the time an event
+// was recorded.
+type InboxTable struct {
+	Name       string
+	TimeColumn string
+}
+
+// OutboxJanitor removes sent outbox rows and expired inbox records in
+// bounded batches so that cleanup never holds long locks.
+type OutboxJanitor struct {
+	pool   janitorDB
+	outbox string
+	inbox  InboxTable
+	policy RetentionPolicy
+	logger *slog.Logger
+}
+
+func NewOutboxJanitor(pool *pgxpool.Pool, outbox string, inbox InboxTable, policy RetentionPolicy, logger *slog.Logger) *OutboxJanitor {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Hour
+	}
+	if policy.BatchSize < 1 {
+		policy.BatchSize = 1000
+	}
+	return &OutboxJanitor{
+		pool:   pool,
+		outbox: outbox,
+		inbox:  inbox,
+		policy: policy,
+		logger: logger,
+	}
+}
+
+func (j *OutboxJanitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		j.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *OutboxJanitor) sweep(ctx context.Context) {
+	if j.policy.SentRetention > 0 {
+		action, step := "deleted", j.deleteSent
+		if j.policy.Archive {
+			action, step = "archived", j.archiveSent
+		}
+		n, err := j.batches(ctx, j.policy.SentRetention, step)
+		if err != nil {
+			j.logger.Error("outbox cleanup failed", "table", j.outbox, "err", err)
+		} else if n > 0 {
+			j.logger.Info("outbox rows "+action, "table", j.outbox, "rows", n)
+		}
+	}
+
+	if j.policy.InboxRetention > 0 && j.inbox.Name != "" {
+		n, err := j.batches(ctx, j.policy.InboxRetention, j.pruneInbox)
+		if err != nil {
+			j.logger.Error("inbox cleanup failed", "table", j.inbox.Name, "err", err)
+		} else if n > 0 {
+			j.logger.Info("inbox rows pruned", "table", j.inbox.Name, "rows", n)
+		}
+	}
+}
+
+// batches runs step until it handles fewer rows than a full batch.
+func (j *OutboxJanitor) batches(ctx context.Context, retention time.Duration, step func(context.Context, time.Time) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		n, err := step(ctx, time.Now().Add(-retention))
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < int64(j.policy.BatchSize) || ctx.Err() != nil {
+			return total, nil
+		}
+	}
+}
+
+func (j *OutboxJanitor) deleteSent(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := j.pool.Exec(ctx, j.deleteSentQuery(), cutoff, j.policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (j *OutboxJanitor) archiveSent(ctx context.Context, cutoff time.Time) (int64, error) {
+	var selected, moved int64
+	if err := j.pool.QueryRow(ctx, j.archiveSentQuery(), cutoff, j.policy.BatchSize).Scan(&selected, &moved); err != nil {
+		return 0, err
+	}
+	if moved < selected {
+		return moved, fmt.Errorf("%w: %d of %d rows of %s have an id taken in %s_archive", ErrArchiveConflict, selected-moved, selected, j.outbox, j.outbox)
+	}
+	return moved, nil
+}
+
+func (j *OutboxJanitor) pruneInbox(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := j.pool.Exec(ctx, j.pruneInboxQuery(), cutoff, j.policy.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (j *OutboxJanitor) deleteSentQuery() string {
+	return fmt.Sprintf(`
+		DELETE FROM %[1]s
+		WHERE id IN (
+			SELECT id FROM %[1]s
+			WHERE status = 'sent' AND updated_at < $1
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)`, j.outbox)
+}
+
+// archiveSentQuery copies a batch to the archive and deletes from the
+// outbox only the rows whose copy was inserted, so a row is never deleted
+// without being archived and an existing archive row is never overwritten.
+// It returns how many rows were selected and how many were moved.
+func (j *OutboxJanitor) archiveSentQuery() string {
+	return fmt.Sprintf(`
+		WITH batch AS (
+			SELECT id, event_id, event_type, payload, attempts, created_at, updated_at
+			FROM %[1]s
+			WHERE status = 'sent' AND updated_at < $1
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		), archived AS (
+			INSERT INTO %[1]s_archive (id, event_id, event_type, payload, attempts, created_at, sent_at)
+			SELECT id, event_id, event_type, payload, attempts, created_at, updated_at FROM batch
+			ON CONFLICT DO NOTHING
+			RETURNING id
+		), moved AS (
+			DELETE FROM %[1]s
+			WHERE id IN (SELECT id FROM archived)
+			RETURNING id
+		)
+		SELECT (SELECT count(*) FROM batch), (SELECT count(*) FROM moved)`, j.outbox)
+}
+
+func (j *OutboxJanitor) pruneInboxQuery() string {
+	return fmt.Sprintf(`
+		DELETE FROM %[1]s
+		WHERE event_id IN (
+			SELECT event_id FROM %[1]s
+			WHERE %[2]s < $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)`, j.inbox.Name, j.inbox.TimeColumn)
+}
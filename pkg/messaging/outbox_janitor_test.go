@@ -0,0 +1,97 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gozon/pkg/pgtest"
+)
+
+// fakeArchive scripts the archive statement against an in-memory outbox and
+// archive, keyed by id and holding event ids.
+type fakeArchive struct {
+	db      *pgtest.DB
+	outbox  map[int64]string
+	archive map[int64]string
+}
+
+func newFakeArchive(outbox, archive map[int64]string) *fakeArchive {
+	f := &fakeArchive{db: pgtest.New(), outbox: outbox, archive: archive}
+	f.db.On("INSERT INTO order_outbox_archive", func(args []any) pgtest.Result {
+		var ids []int64
+		for id := range f.outbox {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		if limit := args[1].(int); len(ids) > limit {
+			ids = ids[:limit]
+		}
+		var moved int64
+		for _, id := range ids {
+			if _, ok := f.archive[id]; ok {
+				continue
+			}
+			f.archive[id] = f.outbox[id]
+			delete(f.outbox, id)
+			moved++
+		}
+		return pgtest.Row(int64(len(ids)), moved)
+	})
+	return f
+}
+
+func (f *fakeArchive) janitor(batch int) *OutboxJanitor {
+	return &OutboxJanitor{
+		pool:   f.db,
+		outbox: "order_outbox",
+		policy: RetentionPolicy{SentRetention: time.Hour, BatchSize: batch, Archive: true},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestJanitorArchivesInBatches(t *testing.T) {
+	f := newFakeArchive(map[int64]string{1: "a", 2: "b", 3: "c", 4: "d", 5: "e"}, map[int64]string{})
+	j := f.janitor(2)
+
+	n, err := j.batches(context.Background(), j.policy.SentRetention, j.archiveSent)
+	if err != nil || n != 5 {
+		t.Fatalf("archived %d rows, err %v, want 5", n, err)
+	}
+	if len(f.outbox) != 0 || len(f.archive) != 5 {
+		t.Errorf("outbox %v, archive %v, want everything moved", f.outbox, f.archive)
+	}
+	calls := f.db.Calls("INSERT INTO order_outbox_archive")
+	if len(calls) != 3 {
+		t.Errorf("ran %d batches, want 3", len(calls))
+	}
+	if sql := calls[0].SQL; !strings.Contains(sql, "ON CONFLICT DO NOTHING") || strings.Contains(sql, "DO UPDATE") {
+		t.Errorf("archive statement may overwrite archived rows: %s", sql)
+	}
+}
+
+func TestJanitorArchiveConflict(t *testing.T) {
+	// Id 2 is already archived, left over from before the outbox sequence
+	// was reset.
+	f := newFakeArchive(map[int64]string{1: "a", 2: "b", 3: "c"}, map[int64]string{2: "old"})
+	j := f.janitor(10)
+
+	n, err := j.batches(context.Background(), j.policy.SentRetention, j.archiveSent)
+	if !errors.Is(err, ErrArchiveConflict) {
+		t.Fatalf("archive error = %v, want ErrArchiveConflict", err)
+	}
+	if n != 2 {
+		t.Errorf("archived %d rows, want the 2 without a clash", n)
+	}
+	if f.archive[2] != "old" {
+		t.Errorf("archived row 2 = %q, want it left alone", f.archive[2])
+	}
+	if f.outbox[2] != "b" || len(f.outbox) != 1 {
+		t.Errorf("outbox = %v, want only row 2 kept", f.outbox)
+	}
+}
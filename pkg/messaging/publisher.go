@@ -2,23 +2,82 @@ package messaging
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gozon/pkg/contracts"
+	"gozon/pkg/tracing"
 
 	"github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	ErrPublishNacked   = errors.New("publish not acknowledged by broker")
+	ErrUnroutable      = errors.New("message returned as unroutable")
+	ErrPublisherClosed = errors.New("publisher closed")
+)
+
+// Publish sends env with its type as the routing key and returns nil only
+// after the broker has confirmed the message.
 type Publisher interface {
-	Publish(ctx context.Context, routingKey string, payload []byte) error
+	Publish(ctx context.Context, env contracts.Envelope) error
 	Close() error
 }
 
+const publisherPoolSize = 4
+
+type confirmChannel struct {
+	ch      *amqp091.Channel
+	returns chan amqp091.Return
+}
+
 type RabbitPublisher struct {
-	conn     *amqp091.Connection
+	url      string
 	exchange string
+	kind     string
+	encoding Encoding
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	conn  *amqp091.Connection
+	ready chan struct{}
+	pool  chan *confirmChannel
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewRabbitPublisher(url, exchange, kind string, encoding Encoding, logger *slog.Logger) (*RabbitPublisher, error) {
+	if err := validateExchangeKind(kind); err != nil {
+		return nil, err
+	}
+	p := &RabbitPublisher{
+		url:      url,
+		exchange: exchange,
+		kind:     kind,
+		encoding: encoding,
+		logger:   logger,
+		ready:    make(chan struct{}),
+		pool:     make(chan *confirmChannel, publisherPoolSize),
+		done:     make(chan struct{}),
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	p.setConnection(conn)
+
+	return p, nil
 }
 
-func NewRabbitPublisher(url, exchange string) (*RabbitPublisher, error) {
-	conn, err := amqp091.Dial(url)
+func (p *RabbitPublisher) dial() (*amqp091.Connection, error) {
+	conn, err := amqp091.Dial(p.url)
 	if err != nil {
 		return nil, fmt.Errorf("connect rabbitmq: %w", err)
 	}
@@ -30,35 +89,192 @@ func NewRabbitPublisher(url, exchange string) (*RabbitPublisher, error) {
 	}
 	defer ch.Close()
 
-	if err := ch.ExchangeDeclare(
-		exchange,
-		"fanout",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	); err != nil {
+	if err := declareExchange(ch, p.exchange, p.kind); err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("declare exchange: %w", err)
+		return nil, err
 	}
 
-	return &RabbitPublisher{conn: conn, exchange: exchange}, nil
+	return conn, nil
+}
+
+func (p *RabbitPublisher) setConnection(conn *amqp091.Connection) {
+	p.mu.Lock()
+	p.conn = conn
+	close(p.ready)
+	p.mu.Unlock()
+
+	go p.watch(conn)
 }
 
-func (p *RabbitPublisher) Publish(ctx context.Context, routingKey string, payload []byte) error {
-	ch, err := p.conn.Channel()
+func (p *RabbitPublisher) watch(conn *amqp091.Connection) {
+	closed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+	var reason *amqp091.Error
+	select {
+	case <-p.done:
+		return
+	case reason = <-closed:
+	}
+
+	p.mu.Lock()
+	p.conn = nil
+	p.ready = make(chan struct{})
+	p.mu.Unlock()
+	p.drainPool()
+
+	p.logger.Warn("rabbitmq publisher connection lost", "exchange", p.exchange, "err", reason)
+
+	for attempt := 1; ; attempt++ {
+		delay := backoffDelay(attempt)
+		select {
+		case <-p.done:
+			return
+		case <-time.After(delay):
+		}
+
+		conn, err := p.dial()
+		if err == nil {
+			p.logger.Info("rabbitmq publisher reconnected", "exchange", p.exchange)
+			p.setConnection(conn)
+			return
+		}
+
+		p.logger.Warn("rabbitmq publisher reconnect failed", "exchange", p.exchange, "attempt", attempt, "err", err)
+	}
+}
+
+// Publish sends env and waits for the broker confirm. The trace context
+// of ctx travels in the message headers.
+func (p *RabbitPublisher) Publish(ctx context.Context, env contracts.Envelope) (err error) {
+	msg, err := envelopePublishing(env, p.encoding)
 	if err != nil {
-		return fmt.Errorf("open channel: %w", err)
+		return err
 	}
-	defer ch.Close()
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+	messageID := msg.MessageId
 
-	return ch.PublishWithContext(ctx, p.exchange, routingKey, false, false, amqp091.Publishing{
-		ContentType: "application/json",
-		Body:        payload,
-	})
+	ctx, span := startPublishSpan(ctx, p.exchange, env.Type, &msg)
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+
+	cc, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	confirm, err := cc.ch.PublishWithDeferredConfirmWithContext(ctx, p.exchange, env.Type, true, false, msg)
+	if err != nil {
+		cc.ch.Close()
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		// The confirmation may still arrive later; the channel can no longer
+		// be reused without mixing it up with the next message.
+		cc.ch.Close()
+		return fmt.Errorf("wait for confirm: %w", err)
+	}
+	if !acked {
+		p.release(cc)
+		return ErrPublishNacked
+	}
+
+	// The broker sends basic.return before the ack of the same message, so
+	// a returned message is already buffered once the ack is observed.
+	select {
+	case ret := <-cc.returns:
+		p.release(cc)
+		if ret.MessageId == messageID {
+			return fmt.Errorf("%w: %d %s", ErrUnroutable, ret.ReplyCode, ret.ReplyText)
+		}
+	default:
+		p.release(cc)
+	}
+
+	return nil
+}
+
+func (p *RabbitPublisher) acquire(ctx context.Context) (*confirmChannel, error) {
+	for {
+		select {
+		case cc := <-p.pool:
+			if !cc.ch.IsClosed() {
+				return cc, nil
+			}
+			continue
+		default:
+		}
+
+		p.mu.Lock()
+		conn, ready := p.conn, p.ready
+		p.mu.Unlock()
+
+		if conn == nil {
+			select {
+			case <-ready:
+				continue
+			case <-p.done:
+				return nil, ErrPublisherClosed
+			case <-ctx.Done():
+				return nil, fmt.Errorf("wait for rabbitmq connection: %w", ctx.Err())
+			}
+		}
+
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, fmt.Errorf("open channel: %w", err)
+		}
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("enable confirms: %w", err)
+		}
+		return &confirmChannel{
+			ch:      ch,
+			returns: ch.NotifyReturn(make(chan amqp091.Return, 1)),
+		}, nil
+	}
+}
+
+func (p *RabbitPublisher) release(cc *confirmChannel) {
+	select {
+	case p.pool <- cc:
+	default:
+		cc.ch.Close()
+	}
+}
+
+func (p *RabbitPublisher) drainPool() {
+	for {
+		select {
+		case cc := <-p.pool:
+			cc.ch.Close()
+		default:
+			return
+		}
+	}
 }
 
 func (p *RabbitPublisher) Close() error {
-	return p.conn.Close()
+	p.closeOnce.Do(func() { close(p.done) })
+	p.drainPool()
+
+	p.mu.Lock()
+	conn := p.conn
+	p.conn = nil
+	p.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func newMessageID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
@@ -0,0 +1,166 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	HeaderAttempts           = "x-attempts"
+	HeaderOriginalExchange   = "x-original-exchange"
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+	HeaderLastError          = "x-last-error"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Delays      []time.Duration
+}
+
+var defaultRetryDelays = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if attempt > len(p.Delays) {
+		attempt = len(p.Delays)
+	}
+	return p.Delays[attempt-1]
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as not worth retrying; the message goes
+// straight to the parking queue.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+func DeadLetterExchange(queue string) string {
+	return queue + ".dlx"
+}
+
+func DeadLetterQueue(queue string) string {
+	return queue + ".dlq"
+}
+
+func ParkingQueue(queue string) string {
+	return queue + ".parking"
+}
+
+func retryQueue(queue string, delay time.Duration) string {
+	return queue + ".retry." + delay.String()
+}
+
+func declareRetryTopology(ch amqpChannel, queue string, policy RetryPolicy) error {
+	dlx := DeadLetterExchange(queue)
+	if err := ch.ExchangeDeclare(dlx, "fanout", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter exchange: %w", err)
+	}
+	if _, err := ch.QueueDeclare(DeadLetterQueue(queue), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter queue: %w", err)
+	}
+	if err := ch.QueueBind(DeadLetterQueue(queue), "", dlx, false, nil); err != nil {
+		return fmt.Errorf("bind dead-letter queue: %w", err)
+	}
+	if _, err := ch.QueueDeclare(ParkingQueue(queue), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare parking queue: %w", err)
+	}
+
+	for _, delay := range policy.Delays {
+		args := amqp091.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		}
+		if _, err := ch.QueueDeclare(retryQueue(queue, delay), true, false, false, false, args); err != nil {
+			return fmt.Errorf("declare retry queue: %w", err)
+		}
+	}
+	return nil
+}
+
+func attempts(msg amqp091.Delivery) int {
+	switch v := msg.Headers[HeaderAttempts].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// restoreRoutingKey puts back the routing key the message was originally
+// published with; retried messages reach the queue via the default exchange
+// and carry the queue name instead.
+func restoreRoutingKey(msg *amqp091.Delivery) {
+	if key, ok := msg.Headers[HeaderOriginalRoutingKey].(string); ok {
+		msg.RoutingKey = key
+	}
+	if exchange, ok := msg.Headers[HeaderOriginalExchange].(string); ok {
+		msg.Exchange = exchange
+	}
+}
+
+func republishing(msg amqp091.Delivery, attempt int, cause error) amqp091.Publishing {
+	headers := amqp091.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderAttempts] = int32(attempt)
+	headers[HeaderOriginalExchange] = msg.Exchange
+	headers[HeaderOriginalRoutingKey] = msg.RoutingKey
+	headers[HeaderLastError] = truncate(cause.Error(), 1024)
+
+	return amqp091.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp091.Persistent,
+		CorrelationId: msg.CorrelationId,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Type:          msg.Type,
+		AppId:         msg.AppId,
+		Body:          msg.Body,
+	}
+}
+
+func publishConfirmed(ctx context.Context, ch amqpChannel, exchange, key string, msg amqp091.Publishing) error {
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
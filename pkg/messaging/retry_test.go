@@ -0,0 +1,165 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, Delays: defaultRetryDelays}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 10 * time.Second},
+		{3, time.Minute},
+		{4, time.Minute},
+		{10, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			if got := policy.delay(tt.attempt); got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopologyNames(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{DeadLetterExchange("payments.orders"), "payments.orders.dlx"},
+		{DeadLetterQueue("payments.orders"), "payments.orders.dlq"},
+		{ParkingQueue("payments.orders"), "payments.orders.parking"},
+		{retryQueue("payments.orders", time.Second), "payments.orders.retry.1s"},
+		{retryQueue("payments.orders", time.Minute), "payments.orders.retry.1m0s"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+
+	// Every delay needs its own queue, since the TTL is set per queue.
+	seen := map[string]bool{}
+	for _, d := range defaultRetryDelays {
+		name := retryQueue("q", d)
+		if seen[name] {
+			t.Errorf("retry queue %q used for two delays", name)
+		}
+		seen[name] = true
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	base := errors.New("bad payload")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "plain", err: base},
+		{name: "permanent", err: Permanent(base), want: true},
+		{name: "wrapped permanent", err: fmt.Errorf("handle: %w", Permanent(base)), want: true},
+		{name: "nil", err: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("IsPermanent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) != nil")
+	}
+	if !errors.Is(Permanent(base), base) {
+		t.Error("Permanent() hides the cause from errors.Is")
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp091.Table
+		want    int
+	}{
+		{name: "no headers", want: 0},
+		{name: "int32", headers: amqp091.Table{HeaderAttempts: int32(2)}, want: 2},
+		{name: "int64", headers: amqp091.Table{HeaderAttempts: int64(3)}, want: 3},
+		{name: "int", headers: amqp091.Table{HeaderAttempts: 4}, want: 4},
+		{name: "string is ignored", headers: amqp091.Table{HeaderAttempts: "5"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attempts(amqp091.Delivery{Headers: tt.headers}); got != tt.want {
+				t.Errorf("attempts() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepublishingRoundTrip(t *testing.T) {
+	original := amqp091.Delivery{
+		Headers:     amqp091.Table{"traceparent": "00-abc-def-01"},
+		ContentType: "application/json",
+		MessageId:   "m-1",
+		Type:        "order.created",
+		Body:        []byte(`{}`),
+		Exchange:    "orders.events",
+		RoutingKey:  "order.created",
+	}
+
+	retry := republishing(original, 1, errors.New("db down"))
+	if retry.Headers[HeaderAttempts] != int32(1) || retry.Headers[HeaderLastError] != "db down" {
+		t.Errorf("headers = %v", retry.Headers)
+	}
+	if retry.Headers["traceparent"] != "00-abc-def-01" {
+		t.Error("existing headers were not carried over")
+	}
+	if _, ok := original.Headers[HeaderAttempts]; ok {
+		t.Error("republishing() modified the delivery's headers")
+	}
+	if retry.MessageId != original.MessageId || string(retry.Body) != string(original.Body) || retry.DeliveryMode != amqp091.Persistent {
+		t.Errorf("publishing = %+v", retry)
+	}
+
+	// The retry comes back through the default exchange keyed by queue
+	// name; the handler must still see where it was first published.
+	redelivered := amqp091.Delivery{Headers: retry.Headers, Exchange: "", RoutingKey: "payments.orders"}
+	restoreRoutingKey(&redelivered)
+	if redelivered.Exchange != "orders.events" || redelivered.RoutingKey != "order.created" {
+		t.Errorf("restored %q/%q", redelivered.Exchange, redelivered.RoutingKey)
+	}
+	if got := attempts(redelivered); got != 1 {
+		t.Errorf("attempts() = %d, want 1", got)
+	}
+
+	// A second failure keeps the original origin, not the retry queue.
+	again := republishing(redelivered, 2, errors.New("still down"))
+	if again.Headers[HeaderOriginalRoutingKey] != "order.created" || again.Headers[HeaderAttempts] != int32(2) {
+		t.Errorf("second retry headers = %v", again.Headers)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("truncate() = %q", got)
+	}
+	if got := truncate(strings.Repeat("a", 20), 10); got != strings.Repeat("a", 10) {
+		t.Errorf("truncate() = %q", got)
+	}
+	// Cutting inside a multi-byte rune must not leave invalid UTF-8.
+	got := truncate("ошибка", 3)
+	if !utf8.ValidString(got) || got != "о" {
+		t.Errorf("truncate() = %q", got)
+	}
+}
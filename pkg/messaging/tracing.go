@@ -0,0 +1,56 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("gozon/pkg/messaging")
+
+// headerCarrier lets the propagator read and write trace context in AMQP
+// message headers.
+type headerCarrier amqp091.Table
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startPublishSpan starts a producer span for msg and writes its context
+// into the message headers, where Consumer picks it up.
+func startPublishSpan(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, exchange+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messageAttributes(exchange, routingKey, msg.MessageId)...),
+	)
+	if msg.Headers == nil {
+		msg.Headers = amqp091.Table{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Headers))
+	return ctx, span
+}
+
+func messageAttributes(exchange, routingKey, messageID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination.name", exchange),
+		attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+		attribute.String("messaging.message.id", messageID),
+	}
+}
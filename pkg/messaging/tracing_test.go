@@ -0,0 +1,248 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gozon/pkg/contracts"
+	"gozon/pkg/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// spanRecorder installs a recording tracer provider once per test binary;
+// tests tell their spans apart by trace ID.
+func spanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return recorder
+}
+
+func spansOf(rec *tracetest.SpanRecorder, traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID() == traceID {
+			spans[s.Name()] = s
+		}
+	}
+	return spans
+}
+
+// captureTx records the arguments of the last Exec call.
+type captureTx struct {
+	pgx.Tx
+	args []any
+}
+
+func (tx *captureTx) Exec(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+	tx.args = args
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+// wirePublisher stands in for RabbitPublisher up to the socket: it encodes
+// the envelope and starts the producer span the same way, then keeps the
+// message instead of sending it.
+type wirePublisher struct {
+	exchange string
+	sent     []amqp091.Publishing
+}
+
+func (p *wirePublisher) Publish(ctx context.Context, env contracts.Envelope) error {
+	msg, err := envelopePublishing(env, EncodingEnvelope)
+	if err != nil {
+		return err
+	}
+	_, span := startPublishSpan(ctx, p.exchange, env.Type, &msg)
+	defer span.End()
+	p.sent = append(p.sent, msg)
+	return nil
+}
+
+func (p *wirePublisher) Close() error { return nil }
+
+func TestHeaderCarrierRoundTrip(t *testing.T) {
+	spanRecorder(t)
+	ctx, span := otel.Tracer("test").Start(context.Background(), "parent")
+	defer span.End()
+
+	headers := amqp091.Table{HeaderSchemaVersion: int32(1), "count": int64(3)}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatalf("traceparent not written: %v", headers)
+	}
+
+	got := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(headers)))
+	if !got.IsRemote() || got.TraceID() != span.SpanContext().TraceID() || got.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("extracted %v, want trace %s span %s", got, span.SpanContext().TraceID(), span.SpanContext().SpanID())
+	}
+
+	// Non-string header values are not trace context and must be ignored.
+	if v := headerCarrier(headers).Get("count"); v != "" {
+		t.Errorf("Get(count) = %q, want empty", v)
+	}
+	if v := headerCarrier(amqp091.Table{}).Get("traceparent"); v != "" {
+		t.Errorf("Get on empty table = %q", v)
+	}
+}
+
+func TestInsertOutboxStoresTraceContext(t *testing.T) {
+	rec := spanRecorder(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "order.Create")
+	env := contracts.Envelope{ID: "evt-1", Type: contracts.EventOrderCreated, SchemaVersion: 1}
+
+	tx := &captureTx{}
+	if err := InsertOutbox(ctx, tx, "order_outbox", env); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	if len(tx.args) != 4 {
+		t.Fatalf("Exec args = %v", tx.args)
+	}
+	var stored map[string]string
+	if err := json.Unmarshal(tx.args[3].([]byte), &stored); err != nil {
+		t.Fatalf("trace_context is not a JSON map: %v", err)
+	}
+
+	insert := spansOf(rec, parent.SpanContext().TraceID())["order_outbox insert"]
+	if insert == nil {
+		t.Fatal("no order_outbox insert span")
+	}
+	if insert.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("insert span parent = %s, want %s", insert.Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	got := trace.SpanContextFromContext(tracing.Extract(context.Background(), stored))
+	if got.TraceID() != insert.SpanContext().TraceID() || got.SpanID() != insert.SpanContext().SpanID() {
+		t.Errorf("stored trace context %v, want the insert span %v", stored, insert.SpanContext())
+	}
+}
+
+// TestTracePropagatesEndToEnd follows one order from the HTTP request
+// through the outbox, the dispatcher and the broker headers to the
+// consumer's handler, and checks that every hop joins the same trace.
+func TestTracePropagatesEndToEnd(t *testing.T) {
+	rec := spanRecorder(t)
+
+	// HTTP request writes the outbox row.
+	tx := &captureTx{}
+	var traceID trace.TraceID
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		traceID = trace.SpanContextFromContext(r.Context()).TraceID()
+		env, err := contracts.NewEnvelope(r.Context(), "orders-service", contracts.EventOrderCreated, "evt-1",
+			contracts.OrderCreatedEvent{EventID: "evt-1", OrderID: "order-1", UserID: "user-1", Amount: 100})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := InsertOutbox(r.Context(), tx, "order_outbox", env); err != nil {
+			t.Error(err)
+		}
+	})
+	tracing.Middleware(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	// The dispatcher picks the row up later, with a fresh context.
+	var saved map[string]string
+	if err := json.Unmarshal(tx.args[3].([]byte), &saved); err != nil {
+		t.Fatal(err)
+	}
+	// No database here: the pool never connects until the final status
+	// update, which fails after the publish has been traced.
+	pool, err := pgxpool.New(context.Background(), "postgres://gozon@127.0.0.1:1/gozon?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pub := &wirePublisher{exchange: "orders.events"}
+	d := &OutboxDispatcher{pool: pool, publisher: pub, table: "order_outbox"}
+	_ = d.publishOne(context.Background(), outboxRow{
+		ID:           1,
+		EventID:      "evt-1",
+		EventType:    contracts.EventOrderCreated,
+		Payload:      tx.args[2].([]byte),
+		CreatedAt:    time.Now(),
+		TraceContext: saved,
+	})
+	if len(pub.sent) != 1 {
+		t.Fatalf("published %d messages, want 1", len(pub.sent))
+	}
+
+	// The consumer receives the message as the broker delivers it.
+	msg := pub.sent[0]
+	delivery := amqp091.Delivery{
+		Headers:     msg.Headers,
+		ContentType: msg.ContentType,
+		MessageId:   msg.MessageId,
+		Type:        msg.Type,
+		Body:        msg.Body,
+		Exchange:    "orders.events",
+		RoutingKey:  contracts.EventOrderCreated,
+	}
+	c := &Consumer{queue: "payments.orders"}
+	handled := false
+	c.handle(context.Background(), nil, delivery, func(ctx context.Context, env contracts.Envelope) error {
+		_, span := otel.Tracer("test").Start(ctx, "payment.HandleOrderCreated")
+		span.End()
+		handled = true
+		return nil
+	})
+	if !handled {
+		t.Fatal("handler was not called")
+	}
+
+	spans := spansOf(rec, traceID)
+	chain := []string{
+		"POST /orders",
+		"order_outbox insert",
+		"order_outbox dispatch",
+		"orders.events publish",
+		"payments.orders process",
+		"payment.HandleOrderCreated",
+	}
+	for i, name := range chain {
+		span := spans[name]
+		if span == nil {
+			t.Fatalf("span %q missing from trace %s; have %v", name, traceID, names(spans))
+		}
+		if i == 0 {
+			if span.Parent().IsValid() {
+				t.Errorf("%q has parent %s, want a root span", name, span.Parent().SpanID())
+			}
+			continue
+		}
+		parent := spans[chain[i-1]]
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%q parent = %s, want %q (%s)", name, span.Parent().SpanID(), chain[i-1], parent.SpanContext().SpanID())
+		}
+	}
+}
+
+func names(spans map[string]sdktrace.ReadOnlySpan) []string {
+	out := make([]string, 0, len(spans))
+	for name := range spans {
+		out = append(out, name)
+	}
+	return out
+}
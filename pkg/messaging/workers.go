@@ -0,0 +1,104 @@
+package messaging
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// KeyFunc returns the ordering key of a delivery. Deliveries with the same
+// key are handled one at a time in arrival order; an empty key means the
+// delivery can run on any worker.
+type KeyFunc func(amqp091.Delivery) string
+
+// HeaderKey reads the ordering key from a message header.
+func HeaderKey(name string) KeyFunc {
+	return func(msg amqp091.Delivery) string {
+		key, _ := msg.Headers[name].(string)
+		return key
+	}
+}
+
+// DataKey reads the ordering key from a top-level string field of the
+// event payload, such as "order_id".
+func DataKey(field string) KeyFunc {
+	return func(msg amqp091.Delivery) string {
+		env, err := EnvelopeFromDelivery(msg)
+		if err != nil {
+			return ""
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(env.Data, &fields); err != nil {
+			return ""
+		}
+		var key string
+		_ = json.Unmarshal(fields[field], &key)
+		return key
+	}
+}
+
+// FirstKey tries each KeyFunc in turn and returns the first non-empty key.
+func FirstKey(fns ...KeyFunc) KeyFunc {
+	return func(msg amqp091.Delivery) string {
+		for _, fn := range fns {
+			if key := fn(msg); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+type workerPool struct {
+	queues []chan amqp091.Delivery
+	next   int
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(workers, buffer int, handle func(amqp091.Delivery)) *workerPool {
+	p := &workerPool{queues: make([]chan amqp091.Delivery, workers)}
+	for i := range p.queues {
+		queue := make(chan amqp091.Delivery, buffer)
+		p.queues[i] = queue
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for msg := range queue {
+				handle(msg)
+			}
+		}()
+	}
+	return p
+}
+
+// submit must not be called concurrently.
+func (p *workerPool) submit(key string, msg amqp091.Delivery) {
+	var i int
+	if key == "" {
+		i = p.next
+		p.next = (p.next + 1) % len(p.queues)
+	} else {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		i = int(h.Sum32() % uint32(len(p.queues)))
+	}
+	p.queues[i] <- msg
+}
+
+// close stops accepting work; queued deliveries are still handled.
+func (p *workerPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+}
+
+func (p *workerPool) wait() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	return done
+}
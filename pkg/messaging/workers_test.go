@@ -0,0 +1,139 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gozon/pkg/contracts"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestWorkerPoolKeepsKeyOrder(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = map[string][]int{}
+	)
+	pool := newWorkerPool(4, 8, func(msg amqp091.Delivery) {
+		key := msg.Headers["key"].(string)
+		seq := int(msg.Headers["seq"].(int32))
+		// Give other workers a chance to overtake if ordering were broken.
+		time.Sleep(time.Duration(seq%3) * time.Millisecond)
+		mu.Lock()
+		seen[key] = append(seen[key], seq)
+		mu.Unlock()
+	})
+
+	keys := []string{"order-a", "order-b", "order-c", "order-d", "order-e"}
+	const perKey = 20
+	for seq := 0; seq < perKey; seq++ {
+		for _, key := range keys {
+			pool.submit(key, amqp091.Delivery{Headers: amqp091.Table{"key": key, "seq": int32(seq)}})
+		}
+	}
+	pool.close()
+	<-pool.wait()
+
+	for _, key := range keys {
+		got := seen[key]
+		if len(got) != perKey {
+			t.Fatalf("%s: handled %d messages, want %d", key, len(got), perKey)
+		}
+		for i, seq := range got {
+			if seq != i {
+				t.Fatalf("%s handled out of order: %v", key, got)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolRunsUnkeyedInParallel(t *testing.T) {
+	const workers = 3
+	var started sync.WaitGroup
+	started.Add(workers)
+	release := make(chan struct{})
+	pool := newWorkerPool(workers, 1, func(amqp091.Delivery) {
+		started.Done()
+		<-release
+	})
+
+	for i := 0; i < workers; i++ {
+		pool.submit("", amqp091.Delivery{})
+	}
+
+	// Every handler blocks until all of them have started, which only
+	// happens if they run on different workers.
+	all := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(all)
+	}()
+	select {
+	case <-all:
+	case <-time.After(2 * time.Second):
+		t.Fatal("unkeyed deliveries did not run in parallel")
+	}
+	close(release)
+	pool.close()
+	<-pool.wait()
+}
+
+func TestWorkerPoolDrainsOnClose(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		handled int
+	)
+	pool := newWorkerPool(2, 10, func(amqp091.Delivery) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	})
+	for i := 0; i < 10; i++ {
+		pool.submit(fmt.Sprint(i), amqp091.Delivery{})
+	}
+	pool.close()
+
+	select {
+	case <-pool.wait():
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait() did not return after close")
+	}
+	if handled != 10 {
+		t.Errorf("handled %d deliveries before wait returned, want 10", handled)
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	data, _ := json.Marshal(map[string]any{"order_id": "o-1", "amount": 100})
+	body, _ := json.Marshal(contracts.Envelope{ID: "e", Type: contracts.EventOrderCreated, SchemaVersion: 1, Data: data})
+	withData := amqp091.Delivery{Body: body}
+	withHeader := amqp091.Delivery{Headers: amqp091.Table{"x-order-id": "o-2"}, Body: body}
+
+	tests := []struct {
+		name string
+		fn   KeyFunc
+		msg  amqp091.Delivery
+		want string
+	}{
+		{name: "header", fn: HeaderKey("x-order-id"), msg: withHeader, want: "o-2"},
+		{name: "missing header", fn: HeaderKey("x-order-id"), msg: withData, want: ""},
+		{name: "data field", fn: DataKey("order_id"), msg: withData, want: "o-1"},
+		{name: "non-string data field", fn: DataKey("amount"), msg: withData, want: ""},
+		{name: "missing data field", fn: DataKey("user_id"), msg: withData, want: ""},
+		{name: "undecodable body", fn: DataKey("order_id"), msg: amqp091.Delivery{Body: []byte("{")}, want: ""},
+		{name: "first key prefers header", fn: FirstKey(HeaderKey("x-order-id"), DataKey("order_id")), msg: withHeader, want: "o-2"},
+		{name: "first key falls back", fn: FirstKey(HeaderKey("x-order-id"), DataKey("order_id")), msg: withData, want: "o-1"},
+		{name: "first key none", fn: FirstKey(), msg: withData, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fn(tt.msg); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func sampleSum(t *testing.T, route, code string) (uint64, float64) {
+	t.Helper()
+	observer, err := httpDuration.GetMetricWithLabelValues(route, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m dto.Metric
+	if err := observer.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestInstrumentMuxLabelsByPattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := InstrumentMux(mux)
+
+	for _, path := range []string{"/items/1", "/items/2", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if n, _ := sampleSum(t, "GET /items/{id}", "418"); n != 2 {
+		t.Errorf("GET /items/{id} samples = %d, want 2", n)
+	}
+	if n, _ := sampleSum(t, "unmatched", "404"); n != 1 {
+		t.Errorf("unmatched samples = %d, want 1", n)
+	}
+	if testutil.CollectAndCount(httpDuration) < 2 {
+		t.Error("expected at least two label sets")
+	}
+}
+
+func TestInstrumentMuxStopsTimingAtHijack(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		// The connection stays open well past the upgrade.
+		time.Sleep(200 * time.Millisecond)
+	})
+	done := make(chan struct{})
+	instrumented := InstrumentMux(mux)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		instrumented.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/ws")
+	if err == nil {
+		resp.Body.Close()
+	}
+	<-done
+
+	n, sum := sampleSum(t, "GET /ws", "101")
+	if n != 1 {
+		t.Fatalf("GET /ws samples = %d, want 1", n)
+	}
+	if sum >= 0.2 {
+		t.Errorf("recorded %.3fs, want the time up to the hijack only", sum)
+	}
+}
+
+func TestStatusRecorderKeepsFirstStatus(t *testing.T) {
+	rec := &statusRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+	rec.WriteHeader(http.StatusCreated)
+	rec.WriteHeader(http.StatusInternalServerError)
+	if rec.status != http.StatusCreated {
+		t.Errorf("status = %d, want %d", rec.status, http.StatusCreated)
+	}
+
+	rec = &statusRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+	_, _ = rec.Write([]byte(strings.Repeat("x", 3)))
+	rec.WriteHeader(http.StatusBadRequest)
+	if rec.status != http.StatusOK {
+		t.Errorf("status after implicit 200 = %d, want %d", rec.status, http.StatusOK)
+	}
+}
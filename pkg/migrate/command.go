@@ -0,0 +1,87 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = "usage: migrate up | down [N] | status | redo"
+
+var errUsage = errors.New(usage)
+
+// Command runs a migrate subcommand given its arguments, writing a report to
+// out. It backs the "migrate" subcommand of the service binaries.
+func Command(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch cmd := args[0]; cmd {
+	case "up":
+		if len(args) > 1 {
+			return errUsage
+		}
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migration(s)\n", n)
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 2 {
+			return errUsage
+		}
+		if len(args) == 2 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil || v < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = v
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reverted %d migration(s)\n", n)
+		return nil
+
+	case "redo":
+		if len(args) > 1 {
+			return errUsage
+		}
+		if err := m.Redo(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "redone last migration")
+		return nil
+
+	case "status":
+		if len(args) > 1 {
+			return errUsage
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, st := range statuses {
+			appliedAt := "-"
+			if !st.AppliedAt.IsZero() {
+				appliedAt = st.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", st.Version, st.Name, st.State, appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return fmt.Errorf("unknown migrate command %q; %s", cmd, usage)
+	}
+}
@@ -0,0 +1,347 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration was modified")
+	ErrIrreversible     = errors.New("migration has no down script")
+	ErrUnknownMigration = errors.New("applied migration is not known to this binary")
+)
+
+// lockKey is the advisory lock every migrator takes before touching the
+// schema, so replicas starting together apply migrations one at a time.
+const lockKey int64 = 0x676f7a6f6e // "gozon"
+
+const createTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+// Migration is one schema change. Up is applied by Up and Down reverts it;
+// a migration without Down cannot be rolled back.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Load reads migrations from dir. Files are named NNN_name.up.sql with an
+// optional NNN_name.down.sql; a plain NNN_name.sql is treated as up-only.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		down := strings.HasSuffix(base, ".down")
+		base = strings.TrimSuffix(strings.TrimSuffix(base, ".down"), ".up")
+
+		prefix, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must look like NNN_name.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", entry.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if down {
+			m.Down = string(body)
+			continue
+		}
+		if m.Up != "" {
+			return nil, fmt.Errorf("migration %d has more than one up script", version)
+		}
+		m.Up = string(body)
+		sum := sha256.Sum256(body)
+		m.Checksum = hex.EncodeToString(sum[:])
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+type State string
+
+const (
+	StateApplied  State = "applied"
+	StatePending  State = "pending"
+	StateModified State = "modified"
+	StateMissing  State = "missing"
+)
+
+type Status struct {
+	Version   int64
+	Name      string
+	State     State
+	AppliedAt time.Time
+}
+
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+func New(pool *pgxpool.Pool, migrations []Migration, logger *slog.Logger) *Migrator {
+	return &Migrator{
+		pool:       pool,
+		migrations: migrations,
+		logger:     logger,
+	}
+}
+
+// Up applies every pending migration in version order and returns how many
+// were applied. It refuses to run if an applied migration has changed.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var n int
+	err := m.locked(ctx, func(conn *pgxpool.Conn, done map[int64]applied) error {
+		if err := m.verify(done); err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var n int
+	err := m.locked(ctx, func(conn *pgxpool.Conn, done map[int64]applied) error {
+		for _, version := range latest(done, steps) {
+			mig, err := m.find(version)
+			if err != nil {
+				return err
+			}
+			if err := m.revert(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Redo reverts the last applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) error {
+	return m.locked(ctx, func(conn *pgxpool.Conn, done map[int64]applied) error {
+		versions := latest(done, 1)
+		if len(versions) == 0 {
+			return errors.New("no migrations applied")
+		}
+		mig, err := m.find(versions[0])
+		if err != nil {
+			return err
+		}
+		if err := m.revert(ctx, conn, mig); err != nil {
+			return err
+		}
+		return m.apply(ctx, conn, mig)
+	})
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.locked(ctx, func(_ *pgxpool.Conn, done map[int64]applied) error {
+		for _, mig := range m.migrations {
+			st := Status{Version: mig.Version, Name: mig.Name, State: StatePending}
+			if a, ok := done[mig.Version]; ok {
+				st.State = StateApplied
+				st.AppliedAt = a.appliedAt
+				if a.checksum != mig.Checksum {
+					st.State = StateModified
+				}
+			}
+			out = append(out, st)
+		}
+		for version, a := range done {
+			if _, err := m.find(version); err != nil {
+				out = append(out, Status{Version: version, Name: a.name, State: StateMissing, AppliedAt: a.appliedAt})
+			}
+		}
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, err
+}
+
+// locked runs fn on a single connection holding the migration advisory
+// lock, passing the migrations recorded as applied.
+func (m *Migrator) locked(ctx context.Context, fn func(*pgxpool.Conn, map[int64]applied) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("take migration lock: %w", err)
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	if _, err := conn.Exec(ctx, createTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	rows, err := conn.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("query schema_migrations: %w", err)
+	}
+	done := make(map[int64]applied)
+	for rows.Next() {
+		var version int64
+		var a applied
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		done[version] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, done)
+}
+
+func (m *Migrator) verify(done map[int64]applied) error {
+	for _, mig := range m.migrations {
+		if a, ok := done[mig.Version]; ok && a.checksum != mig.Checksum {
+			return fmt.Errorf("%w: %03d_%s", ErrChecksumMismatch, mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, mig Migration) error {
+	return m.inTx(ctx, conn, mig, "up", mig.Up, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum)
+			VALUES ($1, $2, $3)`, mig.Version, mig.Name, mig.Checksum)
+		return err
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *pgxpool.Conn, mig Migration) error {
+	if mig.Down == "" {
+		return fmt.Errorf("%w: %03d_%s", ErrIrreversible, mig.Version, mig.Name)
+	}
+	return m.inTx(ctx, conn, mig, "down", mig.Down, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+		return err
+	})
+}
+
+func (m *Migrator) inTx(ctx context.Context, conn *pgxpool.Conn, mig Migration, direction, script string, record func(pgx.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return fmt.Errorf("migration %03d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	if err := record(tx); err != nil {
+		return fmt.Errorf("record migration %03d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if m.logger != nil {
+		m.logger.Info("migration "+direction, "version", mig.Version, "name", mig.Name)
+	}
+	return nil
+}
+
+func (m *Migrator) find(version int64) (Migration, error) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, nil
+		}
+	}
+	return Migration{}, fmt.Errorf("%w: version %d", ErrUnknownMigration, version)
+}
+
+// latest returns up to n applied versions, newest first.
+func latest(done map[int64]applied, n int) []int64 {
+	versions := make([]int64, 0, len(done))
+	for version := range done {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	n = max(n, 0)
+	if n < len(versions) {
+		versions = versions[:n]
+	}
+	return versions
+}
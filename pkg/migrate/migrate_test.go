@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/002_items.up.sql":   file("CREATE TABLE items ();"),
+		"m/002_items.down.sql": file("DROP TABLE items;"),
+		"m/001_init.up.sql":    file("CREATE TABLE orders ();"),
+		"m/001_init.down.sql":  file("DROP TABLE orders;"),
+		"m/010_legacy.sql":     file("ALTER TABLE orders ADD x INT;"),
+		"m/README.md":          file("not a migration"),
+	}
+	migrations, err := Load(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		version int64
+		name    string
+		down    bool
+	}{
+		{1, "init", true},
+		{2, "items", true},
+		{10, "legacy", false},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("loaded %d migrations, want %d", len(migrations), len(want))
+	}
+	for i, w := range want {
+		m := migrations[i]
+		if m.Version != w.version || m.Name != w.name || (m.Down != "") != w.down {
+			t.Errorf("migration %d = %+v, want version %d name %q down %v", i, m, w.version, w.name, w.down)
+		}
+	}
+
+	sum := sha256.Sum256([]byte("CREATE TABLE orders ();"))
+	if migrations[0].Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("checksum = %s, want the sha256 of the up script", migrations[0].Checksum)
+	}
+}
+
+func TestLoadChecksumIgnoresDown(t *testing.T) {
+	load := func(down string) string {
+		t.Helper()
+		migrations, err := Load(fstest.MapFS{
+			"m/001_init.up.sql":   file("CREATE TABLE orders ();"),
+			"m/001_init.down.sql": file(down),
+		}, "m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrations[0].Checksum
+	}
+	// Fixing a down script must not make applied databases refuse to start.
+	if load("DROP TABLE orders;") != load("DROP TABLE IF EXISTS orders;") {
+		t.Error("checksum depends on the down script")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{name: "no name", fsys: fstest.MapFS{"m/001.up.sql": file("")}},
+		{name: "bad version", fsys: fstest.MapFS{"m/abc_init.up.sql": file("")}},
+		{name: "down without up", fsys: fstest.MapFS{"m/001_init.down.sql": file("DROP TABLE x;")}},
+		{name: "two up scripts", fsys: fstest.MapFS{"m/001_init.up.sql": file("a"), "m/001_init.sql": file("b")}},
+		{name: "conflicting names", fsys: fstest.MapFS{"m/001_init.up.sql": file("a"), "m/001_other.down.sql": file("b")}},
+		{name: "missing dir", fsys: fstest.MapFS{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.fsys, "m"); err == nil {
+				t.Error("Load() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := New(nil, []Migration{
+		{Version: 1, Name: "init", Checksum: "aaa"},
+		{Version: 2, Name: "items", Checksum: "bbb"},
+	}, nil)
+
+	if err := m.verify(map[int64]applied{1: {checksum: "aaa"}}); err != nil {
+		t.Errorf("verify() error = %v", err)
+	}
+	if err := m.verify(map[int64]applied{1: {checksum: "aaa"}, 2: {checksum: "changed"}}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("verify() error = %v, want ErrChecksumMismatch", err)
+	}
+	// Applied versions this binary does not know are reported by Status,
+	// not treated as a mismatch.
+	if err := m.verify(map[int64]applied{7: {checksum: "zzz"}}); err != nil {
+		t.Errorf("verify() error = %v", err)
+	}
+	if _, err := m.find(7); !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("find() error = %v, want ErrUnknownMigration", err)
+	}
+}
+
+func TestRevertIrreversible(t *testing.T) {
+	m := New(nil, nil, nil)
+	err := m.revert(context.Background(), nil, Migration{Version: 3, Name: "backfill", Up: "UPDATE x SET y = 1"})
+	if !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("revert() error = %v, want ErrIrreversible", err)
+	}
+	if !strings.Contains(err.Error(), "003_backfill") {
+		t.Errorf("error %q does not name the migration", err)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	done := map[int64]applied{1: {}, 5: {}, 3: {}, 2: {}}
+	tests := []struct {
+		n    int
+		want []int64
+	}{
+		{n: 1, want: []int64{5}},
+		{n: 2, want: []int64{5, 3}},
+		{n: 10, want: []int64{5, 3, 2, 1}},
+		{n: 0, want: []int64{}},
+		{n: -1, want: []int64{}},
+	}
+	for _, tt := range tests {
+		got := latest(done, tt.n)
+		if len(got) != len(tt.want) {
+			t.Errorf("latest(%d) = %v, want %v", tt.n, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("latest(%d) = %v, want %v", tt.n, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command", args: nil},
+		{name: "unknown command", args: []string{"sideways"}},
+		{name: "up with argument", args: []string{"up", "1"}},
+		{name: "down with two arguments", args: []string{"down", "1", "2"}},
+		{name: "down zero", args: []string{"down", "0"}},
+		{name: "down not a number", args: []string{"down", "all"}},
+		{name: "redo with argument", args: []string{"redo", "1"}},
+		{name: "status with argument", args: []string{"status", "all"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Usage errors are reported before the migrator touches the
+			// database, so a nil migrator is enough.
+			var out bytes.Buffer
+			if err := Command(context.Background(), nil, tt.args, &out); err == nil {
+				t.Error("Command() succeeded, want error")
+			}
+			if out.Len() != 0 {
+				t.Errorf("Command() wrote %q on a usage error", out.String())
+			}
+		})
+	}
+}
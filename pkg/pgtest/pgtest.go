@@ -0,0 +1,405 @@
+// Package pgtest is a scripted stand-in for a pgx pool in unit tests.
+//
+// A test registers a handler per statement, matched by a fragment of its
+// SQL, and the handler returns the rows or affected count the code under
+// test should see. Handlers usually read and update a small in-memory model
+// of the tables involved. There are no locks or isolation: statements take
+// effect as they run, and a test checks Commits and Rollbacks to see how a
+// transaction ended.
+package pgtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Result is what a scripted statement returns.
+type Result struct {
+	Rows     [][]any
+	Affected int64
+	Err      error
+}
+
+// Rows returns a result with the given rows, one slice of column values
+// each.
+func Rows(rows ...[]any) Result {
+	return Result{Rows: rows, Affected: int64(len(rows))}
+}
+
+// Row returns a result with a single row.
+func Row(values ...any) Result {
+	return Rows(values)
+}
+
+// Affected returns a result for a statement that changed n rows.
+func Affected(n int64) Result {
+	return Result{Affected: n}
+}
+
+// Fail returns a result that fails the statement with err.
+func Fail(err error) Result {
+	return Result{Err: err}
+}
+
+type Handler func(args []any) Result
+
+// Call is one statement the code under test ran. Tx is the number of the
+// transaction it ran in, counting from 1, or 0 outside a transaction.
+type Call struct {
+	SQL  string
+	Args []any
+	Tx   int
+}
+
+type route struct {
+	fragment string
+	handler  Handler
+}
+
+type DB struct {
+	mu        sync.Mutex
+	routes    []route
+	calls     []Call
+	txs       int
+	commits   int
+	rollbacks int
+}
+
+func New() *DB {
+	return &DB{}
+}
+
+// On scripts statements containing fragment. Whitespace in both is
+// collapsed before matching, and a later registration takes precedence
+// over an earlier one that also matches.
+func (db *DB) On(fragment string, h Handler) *DB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.routes = append(db.routes, route{fragment: normalize(fragment), handler: h})
+	return db
+}
+
+// Calls returns the statements run so far that contain fragment.
+func (db *DB) Calls(fragment string) []Call {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	fragment = normalize(fragment)
+	var out []Call
+	for _, c := range db.calls {
+		if strings.Contains(c.SQL, fragment) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (db *DB) Commits() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commits
+}
+
+func (db *DB) Rollbacks() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.rollbacks
+}
+
+func (db *DB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return db.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (db *DB) BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.txs++
+	return &Tx{db: db, n: db.txs}, nil
+}
+
+func (db *DB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return db.exec(0, sql, args)
+}
+
+func (db *DB) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return db.query(0, sql, args)
+}
+
+func (db *DB) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	return db.queryRow(0, sql, args)
+}
+
+func (db *DB) run(tx int, sql string, args []any) Result {
+	db.mu.Lock()
+	sql = normalize(sql)
+	db.calls = append(db.calls, Call{SQL: sql, Args: args, Tx: tx})
+	var h Handler
+	for i := len(db.routes) - 1; i >= 0; i-- {
+		if strings.Contains(sql, db.routes[i].fragment) {
+			h = db.routes[i].handler
+			break
+		}
+	}
+	db.mu.Unlock()
+
+	// The handler runs unlocked so that it may inspect the DB itself.
+	if h == nil {
+		return Fail(fmt.Errorf("pgtest: no handler for %q", sql))
+	}
+	return h(args)
+}
+
+func (db *DB) exec(tx int, sql string, args []any) (pgconn.CommandTag, error) {
+	res := db.run(tx, sql, args)
+	if res.Err != nil {
+		return pgconn.CommandTag{}, res.Err
+	}
+	verb, _, _ := strings.Cut(normalize(sql), " ")
+	return pgconn.NewCommandTag(fmt.Sprintf("%s %d", strings.ToUpper(verb), res.Affected)), nil
+}
+
+func (db *DB) query(tx int, sql string, args []any) (pgx.Rows, error) {
+	res := db.run(tx, sql, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &rows{rows: res.Rows, pos: -1}, nil
+}
+
+func (db *DB) queryRow(tx int, sql string, args []any) pgx.Row {
+	res := db.run(tx, sql, args)
+	return &row{rows: res.Rows, err: res.Err}
+}
+
+// Tx is a transaction on a DB. Statements run on it are recorded with its
+// number; it only counts how it ended.
+type Tx struct {
+	db     *DB
+	n      int
+	closed bool
+}
+
+var _ pgx.Tx = (*Tx)(nil)
+
+func (tx *Tx) Begin(context.Context) (pgx.Tx, error) {
+	return nil, errors.New("pgtest: nested transactions are not supported")
+}
+
+func (tx *Tx) Commit(context.Context) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.db.commits++
+	return nil
+}
+
+func (tx *Tx) Rollback(context.Context) error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.db.rollbacks++
+	return nil
+}
+
+func (tx *Tx) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 0, errors.New("pgtest: CopyFrom is not supported")
+}
+
+func (tx *Tx) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	panic("pgtest: SendBatch is not supported")
+}
+
+func (tx *Tx) LargeObjects() pgx.LargeObjects {
+	panic("pgtest: LargeObjects is not supported")
+}
+
+func (tx *Tx) Prepare(context.Context, string, string) (*pgconn.StatementDescription, error) {
+	return nil, errors.New("pgtest: Prepare is not supported")
+}
+
+func (tx *Tx) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if err := tx.check(); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	return tx.db.exec(tx.n, sql, args)
+}
+
+func (tx *Tx) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if err := tx.check(); err != nil {
+		return nil, err
+	}
+	return tx.db.query(tx.n, sql, args)
+}
+
+func (tx *Tx) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	if err := tx.check(); err != nil {
+		return &row{err: err}
+	}
+	return tx.db.queryRow(tx.n, sql, args)
+}
+
+func (tx *Tx) Conn() *pgx.Conn {
+	return nil
+}
+
+func (tx *Tx) check() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	return nil
+}
+
+type row struct {
+	rows [][]any
+	err  error
+}
+
+func (r *row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.rows) == 0 {
+		return pgx.ErrNoRows
+	}
+	return scan(r.rows[0], dest)
+}
+
+type rows struct {
+	rows   [][]any
+	pos    int
+	closed bool
+}
+
+func (r *rows) Close()     { r.closed = true }
+func (r *rows) Err() error { return nil }
+func (r *rows) CommandTag() pgconn.CommandTag {
+	return pgconn.NewCommandTag(fmt.Sprintf("SELECT %d", len(r.rows)))
+}
+func (r *rows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *rows) RawValues() [][]byte                          { return nil }
+func (r *rows) Conn() *pgx.Conn                              { return nil }
+
+func (r *rows) Next() bool {
+	if r.closed || r.pos+1 >= len(r.rows) {
+		r.closed = true
+		return false
+	}
+	r.pos++
+	return true
+}
+
+func (r *rows) Scan(dest ...any) error {
+	if r.pos < 0 || r.pos >= len(r.rows) {
+		return errors.New("pgtest: Scan called without a current row")
+	}
+	return scan(r.rows[r.pos], dest)
+}
+
+func (r *rows) Values() ([]any, error) {
+	if r.pos < 0 || r.pos >= len(r.rows) {
+		return nil, errors.New("pgtest: Values called without a current row")
+	}
+	return r.rows[r.pos], nil
+}
+
+func scan(values []any, dest []any) error {
+	if len(values) != len(dest) {
+		return fmt.Errorf("pgtest: row has %d columns, Scan got %d targets", len(values), len(dest))
+	}
+	for i := range dest {
+		if err := assign(dest[i], values[i]); err != nil {
+			return fmt.Errorf("pgtest: column %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// assign stores src in the pointer dest the way pgx would for the common
+// cases: same or convertible types of the same kind, NULL into pointers,
+// sql.Scanner targets, and JSON text into maps, slices and structs.
+func assign(dest, src any) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Pointer || dv.IsNil() {
+		return fmt.Errorf("scan target %T is not a non-nil pointer", dest)
+	}
+	target := dv.Elem()
+	if src == nil {
+		target.SetZero()
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+
+	switch {
+	case sv.Type().AssignableTo(target.Type()):
+		target.Set(sv)
+		return nil
+	case target.Kind() == reflect.Pointer:
+		elem := reflect.New(target.Type().Elem())
+		if err := assign(elem.Interface(), src); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	case sameKind(sv.Kind(), target.Kind()) && sv.Type().ConvertibleTo(target.Type()):
+		target.Set(sv.Convert(target.Type()))
+		return nil
+	}
+
+	if scanner, ok := dest.(interface{ Scan(any) error }); ok {
+		return scanner.Scan(src)
+	}
+	if raw, ok := jsonText(src); ok {
+		switch target.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Struct:
+			return json.Unmarshal(raw, dest)
+		}
+	}
+	return fmt.Errorf("cannot scan %T into %T", src, dest)
+}
+
+func sameKind(a, b reflect.Kind) bool {
+	class := func(k reflect.Kind) int {
+		switch k {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return 1
+		case reflect.Float32, reflect.Float64:
+			return 2
+		case reflect.String:
+			return 3
+		case reflect.Bool:
+			return 4
+		}
+		return 0
+	}
+	return class(a) != 0 && class(a) == class(b)
+}
+
+func jsonText(src any) ([]byte, bool) {
+	switch v := src.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
+
+func normalize(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
@@ -0,0 +1,107 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type status string
+
+func TestScan(t *testing.T) {
+	id := uuid.New()
+	now := time.Now()
+	db := New().On("SELECT", func(args []any) Result {
+		return Row(id.String(), "paid", int32(7), now, nil, []byte(`{"traceparent":"x"}`))
+	})
+
+	var (
+		gotID  uuid.UUID
+		st     status
+		n      int64
+		at     *time.Time
+		reason *string
+		trace  map[string]string
+	)
+	err := db.QueryRow(context.Background(), "SELECT id, status, n, at, reason, trace FROM t").Scan(&gotID, &st, &n, &at, &reason, &trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID != id || st != "paid" || n != 7 || at == nil || !at.Equal(now) || reason != nil || trace["traceparent"] != "x" {
+		t.Errorf("scanned %v %v %v %v %v %v", gotID, st, n, at, reason, trace)
+	}
+
+	var s string
+	if err := db.QueryRow(context.Background(), "SELECT 1").Scan(&s); err == nil {
+		t.Error("scanning six columns into one target succeeded")
+	}
+}
+
+func TestRouting(t *testing.T) {
+	db := New().
+		On("UPDATE orders", func([]any) Result { return Affected(1) }).
+		On("UPDATE orders SET status = 'dead'", func([]any) Result { return Affected(2) })
+
+	ctx := context.Background()
+	tag, err := db.Exec(ctx, "UPDATE orders\n\t\tSET status = 'dead'\n\t\tWHERE id = $1", 1)
+	if err != nil || tag.RowsAffected() != 2 {
+		t.Errorf("later registration: %v, %v", tag, err)
+	}
+	tag, err = db.Exec(ctx, "UPDATE orders SET note = $1", "x")
+	if err != nil || tag.RowsAffected() != 1 {
+		t.Errorf("earlier registration: %v, %v", tag, err)
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM orders"); err == nil {
+		t.Error("unscripted statement succeeded")
+	}
+	if calls := db.Calls("UPDATE orders"); len(calls) != 2 || calls[0].Args[0] != 1 {
+		t.Errorf("Calls() = %v", calls)
+	}
+	if err := db.QueryRow(ctx, "UPDATE orders SET x RETURNING id").Scan(new(int)); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("empty result Scan() = %v, want ErrNoRows", err)
+	}
+}
+
+func TestTx(t *testing.T) {
+	db := New().On("SELECT", func([]any) Result { return Rows([]any{1}, []any{2}) })
+	ctx := context.Background()
+
+	tx, _ := db.Begin(ctx)
+	rows, err := tx.Query(ctx, "SELECT n FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		sum += n
+	}
+	if sum != 3 {
+		t.Errorf("sum = %d", sum)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(ctx); !errors.Is(err, pgx.ErrTxClosed) {
+		t.Errorf("Rollback after Commit = %v", err)
+	}
+	if _, err := tx.Exec(ctx, "SELECT 1"); !errors.Is(err, pgx.ErrTxClosed) {
+		t.Errorf("Exec after Commit = %v", err)
+	}
+
+	tx2, _ := db.Begin(ctx)
+	_ = tx2.Rollback(ctx)
+	if db.Commits() != 1 || db.Rollbacks() != 1 {
+		t.Errorf("commits %d, rollbacks %d", db.Commits(), db.Rollbacks())
+	}
+	if calls := db.Calls("SELECT n"); len(calls) != 1 || calls[0].Tx != 1 {
+		t.Errorf("Calls() = %+v", calls)
+	}
+}
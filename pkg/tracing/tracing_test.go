@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetupRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "unknown exporter", cfg: Config{Exporter: "otlp"}},
+		{name: "json-file without a path", cfg: Config{Exporter: ExporterJSONFile}},
+		{name: "json-file in a missing directory", cfg: Config{Exporter: ExporterJSONFile, File: filepath.Join(t.TempDir(), "missing", "spans.json")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Setup(tt.cfg); err == nil {
+				t.Errorf("Setup(%+v) succeeded", tt.cfg)
+			}
+		})
+	}
+}
+
+func TestSetupNonePropagates(t *testing.T) {
+	shutdown, err := Setup(Config{ServiceName: "orders-service", Exporter: ExporterNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	saved := Inject(ctx)
+	if saved["traceparent"] != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("Inject() = %v, want the W3C traceparent", saved)
+	}
+	if got := trace.SpanContextFromContext(Extract(context.Background(), saved)); got.TraceID() != traceID || got.SpanID() != spanID {
+		t.Errorf("Extract() = %s/%s, want the injected context", got.TraceID(), got.SpanID())
+	}
+}
+
+// exportedSpan is the part of a stdouttrace JSON line the tests read.
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ SpanID string }
+	Resource    []struct {
+		Key   string
+		Value struct{ Value any }
+	}
+}
+
+func TestSetupJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(Config{ServiceName: "orders-service", Exporter: ExporterJSONFile, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := otel.Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	parent.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := map[string]exportedSpan{}
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var s exportedSpan
+		if err := json.Unmarshal(lines.Bytes(), &s); err != nil {
+			t.Fatalf("line %q is not a JSON span: %v", lines.Text(), err)
+		}
+		spans[s.Name] = s
+	}
+	if len(spans) != 2 {
+		t.Fatalf("exported %v, want parent and child one per line", spans)
+	}
+	p, c := spans["parent"], spans["child"]
+	if c.SpanContext.TraceID != p.SpanContext.TraceID || c.Parent.SpanID != p.SpanContext.SpanID {
+		t.Errorf("child %+v is not under parent %+v", c, p)
+	}
+	var service any
+	for _, kv := range p.Resource {
+		if kv.Key == "service.name" {
+			service = kv.Value.Value
+		}
+	}
+	if service != "orders-service" {
+		t.Errorf("resource service.name = %v, want orders-service", service)
+	}
+}
+
+func TestMiddlewareSpanName(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	// With no exporter Setup only installs the propagator, leaving the
+	// recording provider in place.
+	if _, err := Setup(Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /orders/{orderID}", func(http.ResponseWriter, *http.Request) {})
+	handler := Middleware(mux)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		traceparent string
+		wantName    string
+		wantRoute   string
+	}{
+		{name: "routed", method: http.MethodGet, path: "/orders/42", wantName: "GET /orders/{orderID}", wantRoute: "GET /orders/{orderID}"},
+		{name: "unrouted", method: http.MethodGet, path: "/nope", wantName: "GET"},
+		{name: "wrong method", method: http.MethodDelete, path: "/orders/42", wantName: "DELETE"},
+		{
+			name: "continues the caller's trace", method: http.MethodGet, path: "/orders/7",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantName:    "GET /orders/{orderID}", wantRoute: "GET /orders/{orderID}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(rec.Ended())
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			ended := rec.Ended()
+			if len(ended) != before+1 {
+				t.Fatalf("ended %d spans, want 1", len(ended)-before)
+			}
+			span := ended[len(ended)-1]
+			if span.Name() != tt.wantName || span.SpanKind() != trace.SpanKindServer {
+				t.Errorf("span %q of kind %s, want server span %q", span.Name(), span.SpanKind(), tt.wantName)
+			}
+			attrs := map[attribute.Key]string{}
+			for _, kv := range span.Attributes() {
+				attrs[kv.Key] = kv.Value.Emit()
+			}
+			if attrs["http.route"] != tt.wantRoute || attrs["url.path"] != tt.path || attrs["http.request.method"] != tt.method {
+				t.Errorf("attributes = %v", attrs)
+			}
+			if tt.traceparent != "" {
+				if got := span.Parent(); got.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || got.SpanID().String() != "00f067aa0ba902b7" || !got.IsRemote() {
+					t.Errorf("parent = %s/%s, want the remote caller's span", got.TraceID(), got.SpanID())
+				}
+			}
+		})
+	}
+}